@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	sfoxapi "github.com/ldcicconi/sfox-api-lib"
+)
+
+// QuarantineConfig decides when a key is taken out of rotation. Once at least MinCalls of the key's
+// last Window calls have been made, and MaxErrorRate of them failed with an auth or rate limit
+// error, every client for the key is held back for Cooldown.
+type QuarantineConfig struct {
+	Window       int      `json:"window"`
+	MinCalls     int      `json:"minCalls"`
+	MaxErrorRate float64  `json:"maxErrorRate"`
+	Cooldown     Duration `json:"cooldown"`
+}
+
+func DefaultQuarantineConfig() QuarantineConfig {
+	return QuarantineConfig{
+		Window:       20,
+		MinCalls:     5,
+		MaxErrorRate: 0.5,
+		Cooldown:     Duration{time.Minute},
+	}
+}
+
+func (c QuarantineConfig) validate(name string) (errs []error) {
+	if c.Window <= 0 {
+		errs = append(errs, fmt.Errorf("clientPools.%s.quarantine: window must be greater than 0", name))
+	}
+	if c.MinCalls <= 0 || c.MinCalls > c.Window {
+		errs = append(errs, fmt.Errorf("clientPools.%s.quarantine: minCalls must be between 1 and window", name))
+	}
+	if c.MaxErrorRate <= 0 || c.MaxErrorRate > 1 {
+		errs = append(errs, fmt.Errorf("clientPools.%s.quarantine: maxErrorRate must be above 0 and at most 1", name))
+	}
+	if c.Cooldown.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("clientPools.%s.quarantine: cooldown must be greater than 0", name))
+	}
+	return errs
+}
+
+var ErrAllClientsQuarantined = fmt.Errorf("every client in the pool is quarantined")
+
+// keyHealth is a rolling window of whether each of a key's recent calls hit a key error
+type keyHealth struct {
+	results          []bool
+	next             int
+	filled           int
+	quarantinedUntil time.Time
+}
+
+func newKeyHealth(window int) *keyHealth {
+	return &keyHealth{results: make([]bool, window)}
+}
+
+func (h *keyHealth) record(failed bool) {
+	h.results[h.next] = failed
+	h.next = (h.next + 1) % len(h.results)
+	if h.filled < len(h.results) {
+		h.filled++
+	}
+}
+
+func (h *keyHealth) errorRate() float64 {
+	failures := 0
+	for i := 0; i < h.filled; i++ {
+		if h.results[i] {
+			failures++
+		}
+	}
+	return float64(failures) / float64(h.filled)
+}
+
+func (h *keyHealth) reset() {
+	h.next, h.filled = 0, 0
+}
+
+func (h *keyHealth) isQuarantined() bool {
+	return !h.quarantinedUntil.IsZero()
+}
+
+// isKeyError reports whether err means there's a problem with the key itself (it's been revoked,
+// or it's being rate limited) rather than with the request
+func isKeyError(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch status, _ := httpStatus(err); status {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests:
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, sign := range []string{"unauthorized", "forbidden", "rate limit", "too many requests", "invalid api key"} {
+		if strings.Contains(msg, sign) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	// "status 429", "status code: 401", "HTTP 403"
+	labelledStatus = regexp.MustCompile(`(?i)\b(?:status(?:\s*code)?|http(?:/\d\.\d)?)\s*[:=]?\s*(\d{3})\b`)
+	// "429 Too Many Requests", only counted if the text is the status's own
+	statusLine = regexp.MustCompile(`\b(\d{3}) ([A-Za-z][A-Za-z -]*)`)
+)
+
+// httpStatus finds the HTTP status of a failed call. Errors that carry it are asked for it, otherwise a
+// number only counts as a status if it's labelled as one or followed by its status text, so an order id
+// or price that happens to contain 429 isn't mistaken for one.
+func httpStatus(err error) (int, bool) {
+	if coded, ok := err.(interface{ StatusCode() int }); ok {
+		return coded.StatusCode(), true
+	}
+	msg := err.Error()
+	if m := labelledStatus.FindStringSubmatch(msg); m != nil {
+		status, _ := strconv.Atoi(m[1])
+		return status, true
+	}
+	for _, m := range statusLine.FindAllStringSubmatch(msg, -1) {
+		status, _ := strconv.Atoi(m[1])
+		if text := http.StatusText(status); text != "" && strings.HasPrefix(strings.ToLower(m[2]), strings.ToLower(text)) {
+			return status, true
+		}
+	}
+	return 0, false
+}
+
+// keyHint identifies a key in logs without giving it away
+func keyHint(key string) string {
+	if len(key) <= 4 {
+		return "..."
+	}
+	return "..." + key[len(key)-4:]
+}
+
+// RecordResult feeds the outcome of a call made with c into its key's health,
+// quarantining the key if it has become unhealthy
+func (pool *SFOXAPIClientPool) RecordResult(c *sfoxapi.SFOXAPI, err error) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	key, ok := pool.keyOf[c]
+	if !ok {
+		return
+	}
+	h := pool.healthOf(key)
+	if h.isQuarantined() {
+		return
+	}
+	h.record(isKeyError(err))
+	if h.filled < pool.quarantine.MinCalls || h.errorRate() < pool.quarantine.MaxErrorRate {
+		return
+	}
+	pool.quarantineKey(key, h, err)
+}
+
+// healthOf must be called with the lock held
+func (pool *SFOXAPIClientPool) healthOf(key string) *keyHealth {
+	h, ok := pool.health[key]
+	if !ok {
+		h = newKeyHealth(pool.quarantine.Window)
+		pool.health[key] = h
+	}
+	return h
+}
+
+// quarantineKey pulls the key's idle clients out of rotation; busy ones follow when they're returned.
+// It must be called with the lock held.
+func (pool *SFOXAPIClientPool) quarantineKey(key string, h *keyHealth, lastErr error) {
+	h.quarantinedUntil = time.Now().Add(pool.quarantine.Cooldown.Duration)
+	h.reset()
+	var stillReady []*sfoxapi.SFOXAPI
+	for _, c := range pool.ready {
+		if pool.keyOf[c] == key {
+			pool.quarantined = append(pool.quarantined, c)
+			continue
+		}
+		stillReady = append(stillReady, c)
+	}
+	pool.ready = stillReady
+	pool.Logger.Printf("[clientPool-%s] [error] quarantining key %s until %s: %s", pool.name, keyHint(key), h.quarantinedUntil.Format(time.RFC3339), lastErr.Error())
+	time.AfterFunc(pool.quarantine.Cooldown.Duration, func() { pool.releaseKey(key) })
+}
+
+func (pool *SFOXAPIClientPool) releaseKey(key string) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	h, ok := pool.health[key]
+	if !ok {
+		// the key was rotated out while it was quarantined
+		return
+	}
+	h.quarantinedUntil = time.Time{}
+	var stillQuarantined []*sfoxapi.SFOXAPI
+	for _, c := range pool.quarantined {
+		if pool.keyOf[c] == key {
+			pool.ready = append(pool.ready, c)
+			continue
+		}
+		stillQuarantined = append(stillQuarantined, c)
+	}
+	pool.quarantined = stillQuarantined
+	pool.Logger.Printf("[clientPool-%s] [info] key %s is back in rotation", pool.name, keyHint(key))
+	pool.notifyAvailable()
+}
+
+// isKeyQuarantined must be called with the lock held
+func (pool *SFOXAPIClientPool) isKeyQuarantined(key string) bool {
+	h, ok := pool.health[key]
+	return ok && h.isQuarantined()
+}
+
+// allQuarantined reports whether no client could ever be handed out until a quarantine ends.
+// It must be called with the lock held.
+func (pool *SFOXAPIClientPool) allQuarantined() bool {
+	live := 0
+	for c, key := range pool.keyOf {
+		if pool.retired[c] {
+			continue
+		}
+		live++
+		if !pool.isKeyQuarantined(key) {
+			return false
+		}
+	}
+	return live > 0
+}
@@ -1,47 +1,300 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"log"
 	"sync"
+	"time"
 
 	sfoxapi "github.com/ldcicconi/sfox-api-lib"
 )
 
 type SFOXAPIClientPool struct {
-	ready []*sfoxapi.SFOXAPI
-	lock  sync.Mutex
+	name           string
+	ready          []*sfoxapi.SFOXAPI
+	quarantined    []*sfoxapi.SFOXAPI          // idle clients whose key is quarantined, see apiClientHealth.go
+	keyOf          map[*sfoxapi.SFOXAPI]string // every live client, ready or checked out, and the key it was built with
+	retired        map[*sfoxapi.SFOXAPI]bool   // checked out clients whose key has been rotated out, dropped once returned
+	size           int                         // total clients spread across the keys, used when clientsPerKey is 0
+	autoSize       bool                        // size follows numOfConnections and the number of keys, rather than config
+	wanted         int                         // the numOfConnections asked for, when autoSize
+	keys           []string                    // the keys in use
+	clientsPerKey  int
+	peakCheckedOut int
+	available      chan struct{} // closed and replaced whenever a client becomes ready, to wake up waiting checkouts
+	health         map[string]*keyHealth
+	quarantine     QuarantineConfig
+	monitor        *sfoxapi.Monitor
+	Logger         *log.Logger
+	lock           sync.Mutex
 }
 
-var ErrNoClientAvailable = fmt.Errorf("no client available in pool")
+// ClientPoolConfig sizes a client pool. Set at most one of Size and ClientsPerKey,
+// with neither set the pool is sized for the number of traders, and has at least one client per key.
+type ClientPoolConfig struct {
+	Size          int              `json:"size"`
+	ClientsPerKey int              `json:"clientsPerKey"`
+	Quarantine    QuarantineConfig `json:"quarantine"`
+}
+
+func (c ClientPoolConfig) validate(name string) (errs []error) {
+	errs = c.Quarantine.validate(name)
+	if c.Size < 0 || c.ClientsPerKey < 0 {
+		errs = append(errs, fmt.Errorf("clientPools.%s: size and clientsPerKey must not be negative", name))
+	}
+	if c.Size > 0 && c.ClientsPerKey > 0 {
+		errs = append(errs, fmt.Errorf("clientPools.%s: set either size or clientsPerKey, not both", name))
+	}
+	return errs
+}
+
+type ClientPoolsConfig struct {
+	Read  ClientPoolConfig `json:"read"`
+	Trade ClientPoolConfig `json:"trade"`
+	// how long a caller waits for a client before giving up
+	CheckoutTimeout Duration `json:"checkoutTimeout"`
+}
 
-func NewSFOXAPIClientPool(apiKeys []string, numOfConnections int) *SFOXAPIClientPool {
-	ready := []*sfoxapi.SFOXAPI{}
-	apiErrorMonitor := sfoxapi.NewMonitor()
-	for i := 0; i < 20; i++ {
-		ready = append(ready, sfoxapi.NewSFOXAPI(apiKeys[i%len(apiKeys)], apiErrorMonitor))
+func DefaultClientPoolsConfig() ClientPoolsConfig {
+	return ClientPoolsConfig{
+		Read:            ClientPoolConfig{Quarantine: DefaultQuarantineConfig()},
+		Trade:           ClientPoolConfig{Quarantine: DefaultQuarantineConfig()},
+		CheckoutTimeout: Duration{5 * time.Second},
 	}
-	apiErrorMonitor.Start()
-	return &SFOXAPIClientPool{
-		ready: ready,
+}
+
+func (c ClientPoolsConfig) validate() (errs []error) {
+	errs = append(c.Read.validate("read"), c.Trade.validate("trade")...)
+	if c.CheckoutTimeout.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("clientPools: checkoutTimeout must be greater than 0"))
+	}
+	return errs
+}
+
+type SFOXAPIClientPoolStats struct {
+	Size           int // live clients, including retired ones still checked out
+	CheckedOut     int
+	PeakCheckedOut int
+	Quarantined    int // idle clients held back because their key is quarantined
+}
+
+var (
+	ErrNoClientAvailable = fmt.Errorf("no client available in pool")
+	ErrCheckoutTimeout   = fmt.Errorf("timed out waiting for a client from the pool")
+)
+
+// NewSFOXAPIClientPool builds numOfConnections clients spread across apiKeys, or one per key if there are
+// more keys than that, unless config says otherwise
+func NewSFOXAPIClientPool(name string, apiKeys []string, numOfConnections int, config ClientPoolConfig, logger *log.Logger) *SFOXAPIClientPool {
+	pool := &SFOXAPIClientPool{
+		name:          name,
+		keyOf:         make(map[*sfoxapi.SFOXAPI]string),
+		retired:       make(map[*sfoxapi.SFOXAPI]bool),
+		size:          config.Size,
+		autoSize:      config.Size == 0 && config.ClientsPerKey == 0,
+		wanted:        numOfConnections,
+		clientsPerKey: config.ClientsPerKey,
+		available:     make(chan struct{}),
+		health:        make(map[string]*keyHealth),
+		quarantine:    config.Quarantine,
+		monitor:       sfoxapi.NewMonitor(),
+		Logger:        logger,
 	}
+	pool.UpdateAPIKeys(apiKeys)
+	pool.monitor.Start()
+	return pool
 }
 
-// NOTE: This could be a blocking call (if there is no api waiting in the ReadyQueue)
+// GetAPIClient returns ErrNoClientAvailable straight away if every client is checked out
 func (pool *SFOXAPIClientPool) GetAPIClient() (*sfoxapi.SFOXAPI, error) {
 	pool.lock.Lock()
 	defer pool.lock.Unlock()
+	return pool.takeReady()
+}
+
+// GetAPIClientContext blocks until a client is returned to the pool, or ctx is done.
+// If ctx's deadline passes first it returns ErrCheckoutTimeout, and if every key is
+// quarantined it returns ErrAllClientsQuarantined rather than waiting out the cooldown.
+func (pool *SFOXAPIClientPool) GetAPIClientContext(ctx context.Context) (*sfoxapi.SFOXAPI, error) {
+	for {
+		pool.lock.Lock()
+		client, err := pool.takeReady()
+		allQuarantined := err != nil && pool.allQuarantined()
+		available := pool.available
+		pool.lock.Unlock()
+		if err == nil {
+			return client, nil
+		}
+		if allQuarantined {
+			return nil, ErrAllClientsQuarantined
+		}
+		select {
+		case <-available:
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, ErrCheckoutTimeout
+			}
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// takeReady must be called with the lock held
+func (pool *SFOXAPIClientPool) takeReady() (*sfoxapi.SFOXAPI, error) {
 	if len(pool.ready) == 0 {
 		return nil, ErrNoClientAvailable
 	}
 	var newReadyQueue []*sfoxapi.SFOXAPI
 	client, newReadyQueue := pool.ready[len(pool.ready)-1], pool.ready[:len(pool.ready)-1]
 	pool.ready = newReadyQueue
+	if checkedOut := pool.checkedOut(); checkedOut > pool.peakCheckedOut {
+		pool.peakCheckedOut = checkedOut
+	}
 	return client, nil
 }
 
 func (pool *SFOXAPIClientPool) ReturnAPIClient(c *sfoxapi.SFOXAPI) {
+	if c == nil {
+		return
+	}
 	pool.lock.Lock()
 	defer pool.lock.Unlock()
+	if _, ok := pool.keyOf[c]; !ok {
+		return
+	}
+	if pool.retired[c] {
+		// its key was rotated out while it was in use, now that the call is done it can go
+		delete(pool.retired, c)
+		delete(pool.keyOf, c)
+		return
+	}
+	if pool.isKeyQuarantined(pool.keyOf[c]) {
+		pool.quarantined = append(pool.quarantined, c)
+		return
+	}
 	pool.ready = append(pool.ready, c)
+	pool.notifyAvailable()
 	return
 }
+
+// KeyOf returns the key c was built with
+func (pool *SFOXAPIClientPool) KeyOf(c *sfoxapi.SFOXAPI) string {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	return pool.keyOf[c]
+}
+
+// checkedOut must be called with the lock held
+func (pool *SFOXAPIClientPool) checkedOut() int {
+	return len(pool.keyOf) - len(pool.ready) - len(pool.quarantined)
+}
+
+// notifyAvailable wakes up everything waiting in GetAPIClientContext. It must be called with the lock held.
+func (pool *SFOXAPIClientPool) notifyAvailable() {
+	close(pool.available)
+	pool.available = make(chan struct{})
+}
+
+// UpdateAPIKeys rebalances the pool onto a new set of keys. Clients are built for keys that are new,
+// and clients for keys that are gone are retired: immediately if they're idle, or when they're
+// returned if a trader is using them, so no in-flight call is cut off.
+func (pool *SFOXAPIClientPool) UpdateAPIKeys(apiKeys []string) (added, retired int) {
+	if len(apiKeys) == 0 {
+		return 0, 0
+	}
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	pool.keys = apiKeys
+	if pool.autoSize {
+		pool.size = pool.wanted
+		if pool.size < len(apiKeys) {
+			pool.size = len(apiKeys)
+		}
+	}
+	want := make(map[string]int)
+	if pool.clientsPerKey > 0 {
+		for _, key := range apiKeys {
+			want[key] = pool.clientsPerKey
+		}
+	} else {
+		for i := 0; i < pool.size; i++ {
+			want[apiKeys[i%len(apiKeys)]]++
+		}
+	}
+	have := make(map[string]int)
+	for c, key := range pool.keyOf {
+		if !pool.retired[c] {
+			have[key]++
+		}
+	}
+	// retire idle clients first, so busy ones only have to be retired if there aren't enough idle ones
+	retireIdle := func(idle []*sfoxapi.SFOXAPI) (kept []*sfoxapi.SFOXAPI) {
+		for _, c := range idle {
+			key := pool.keyOf[c]
+			if have[key] > want[key] {
+				have[key]--
+				delete(pool.keyOf, c)
+				retired++
+				continue
+			}
+			kept = append(kept, c)
+		}
+		return kept
+	}
+	pool.ready = retireIdle(pool.ready)
+	pool.quarantined = retireIdle(pool.quarantined)
+	for c, key := range pool.keyOf {
+		if pool.retired[c] || have[key] <= want[key] {
+			continue
+		}
+		have[key]--
+		pool.retired[c] = true
+		retired++
+	}
+	for _, key := range apiKeys {
+		for ; have[key] < want[key]; have[key]++ {
+			c := sfoxapi.NewSFOXAPI(key, pool.monitor)
+			pool.keyOf[c] = key
+			if pool.isKeyQuarantined(key) {
+				pool.quarantined = append(pool.quarantined, c)
+			} else {
+				pool.ready = append(pool.ready, c)
+			}
+			added++
+		}
+	}
+	for key := range pool.health {
+		if want[key] == 0 {
+			delete(pool.health, key)
+		}
+	}
+	if added > 0 {
+		pool.notifyAvailable()
+	}
+	return added, retired
+}
+
+// Resize changes the numOfConnections the pool is sized for, when it isn't sized by config
+func (pool *SFOXAPIClientPool) Resize(numOfConnections int) (added, retired int) {
+	pool.lock.Lock()
+	if !pool.autoSize || pool.wanted == numOfConnections {
+		pool.lock.Unlock()
+		return 0, 0
+	}
+	pool.wanted = numOfConnections
+	keys := pool.keys
+	pool.lock.Unlock()
+	return pool.UpdateAPIKeys(keys)
+}
+
+func (pool *SFOXAPIClientPool) Stats() SFOXAPIClientPoolStats {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	return SFOXAPIClientPoolStats{
+		Size:           len(pool.keyOf),
+		CheckedOut:     pool.checkedOut(),
+		PeakCheckedOut: pool.peakCheckedOut,
+		Quarantined:    len(pool.quarantined),
+	}
+}
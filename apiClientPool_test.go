@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+)
+
+var testLogger = log.New(ioutil.Discard, "", 0)
+
+func TestUpdateAPIKeysRetiresCheckedOutClientsOnReturn(t *testing.T) {
+	pool := NewSFOXAPIClientPool("test", []string{"key-a", "key-b"}, 20, ClientPoolConfig{Quarantine: DefaultQuarantineConfig()}, testLogger)
+	inUse, err := pool.GetAPIClient()
+	if err != nil {
+		t.Fatalf("unexpected error checking out a client: %s", err.Error())
+	}
+	oldKey := pool.keyOf[inUse]
+
+	added, retired := pool.UpdateAPIKeys([]string{"key-c"})
+	if added != 20 || retired != 20 {
+		t.Errorf("expected 20 clients added and 20 retired, got %d added and %d retired", added, retired)
+	}
+	for _, c := range pool.ready {
+		if pool.keyOf[c] != "key-c" {
+			t.Errorf("client for %s is still ready after its key was rotated out", pool.keyOf[c])
+		}
+	}
+	if pool.keyOf[inUse] != oldKey {
+		t.Errorf("checked out client was dropped before it was returned")
+	}
+
+	pool.ReturnAPIClient(inUse)
+	if _, ok := pool.keyOf[inUse]; ok {
+		t.Errorf("retired client is still tracked after being returned")
+	}
+	if len(pool.ready) != 20 {
+		t.Errorf("expected 20 ready clients, got %d", len(pool.ready))
+	}
+}
+
+func TestClientPoolSizing(t *testing.T) {
+	keys := []string{"key-a", "key-b", "key-c"}
+	if size := NewSFOXAPIClientPool("test", keys, 7, ClientPoolConfig{Quarantine: DefaultQuarantineConfig()}, testLogger).Stats().Size; size != 7 {
+		t.Errorf("expected the pool to honor numOfConnections=7, got %d clients", size)
+	}
+	if size := NewSFOXAPIClientPool("test", keys, 7, ClientPoolConfig{Size: 4, Quarantine: DefaultQuarantineConfig()}, testLogger).Stats().Size; size != 4 {
+		t.Errorf("expected the configured size of 4 to win, got %d clients", size)
+	}
+	pool := NewSFOXAPIClientPool("test", keys, 7, ClientPoolConfig{ClientsPerKey: 2, Quarantine: DefaultQuarantineConfig()}, testLogger)
+	if size := pool.Stats().Size; size != 6 {
+		t.Errorf("expected 2 clients for each of 3 keys, got %d clients", size)
+	}
+	a, _ := pool.GetAPIClient()
+	b, _ := pool.GetAPIClient()
+	pool.ReturnAPIClient(a)
+	stats := pool.Stats()
+	if stats.CheckedOut != 1 || stats.PeakCheckedOut != 2 {
+		t.Errorf("expected 1 checked out with a peak of 2, got %+v", stats)
+	}
+	pool.ReturnAPIClient(b)
+}
+
+func TestClientPoolSizesForKeysAndTraders(t *testing.T) {
+	keys := []string{"key-a", "key-b", "key-c", "key-d"}
+	pool := NewSFOXAPIClientPool("test", keys, 3, ClientPoolConfig{Quarantine: DefaultQuarantineConfig()}, testLogger)
+	if size := pool.Stats().Size; size != 4 {
+		t.Errorf("expected a client for each of the 4 keys, got %d clients", size)
+	}
+	if added, _ := pool.Resize(6); added != 2 || pool.Stats().Size != 6 {
+		t.Errorf("expected the pool to grow to 6 clients, added %d", added)
+	}
+	if _, retired := pool.Resize(2); retired != 2 || pool.Stats().Size != 4 {
+		t.Errorf("expected the pool to shrink back to one client per key, retired %d", retired)
+	}
+	fixed := NewSFOXAPIClientPool("test", keys, 3, ClientPoolConfig{Size: 2, Quarantine: DefaultQuarantineConfig()}, testLogger)
+	if added, retired := fixed.Resize(10); added+retired != 0 || fixed.Stats().Size != 2 {
+		t.Errorf("expected a configured size not to change")
+	}
+}
+
+func TestGetAPIClientContextWaitsForAReturn(t *testing.T) {
+	pool := NewSFOXAPIClientPool("test", []string{"key-a"}, 1, ClientPoolConfig{Quarantine: DefaultQuarantineConfig()}, testLogger)
+	only, _ := pool.GetAPIClient()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := pool.GetAPIClientContext(ctx); err != ErrCheckoutTimeout {
+		t.Errorf("expected ErrCheckoutTimeout from an empty pool, got %v", err)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		pool.ReturnAPIClient(only)
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c, err := pool.GetAPIClientContext(ctx)
+	if err != nil || c != only {
+		t.Errorf("expected to get the returned client, got %v, %v", c, err)
+	}
+}
+
+func TestQuarantinedKeysAreNotHandedOut(t *testing.T) {
+	pool := NewSFOXAPIClientPool("test", []string{"key-a", "key-b"}, 4, ClientPoolConfig{Quarantine: DefaultQuarantineConfig()}, testLogger)
+	rateLimited := fmt.Errorf("429 Too Many Requests")
+	failKey := func() string {
+		c, _ := pool.GetAPIClient()
+		for i := 0; i < pool.quarantine.MinCalls; i++ {
+			pool.RecordResult(c, rateLimited)
+		}
+		pool.ReturnAPIClient(c)
+		return pool.keyOf[c]
+	}
+
+	first := failKey()
+	if !pool.isKeyQuarantined(first) {
+		t.Fatalf("expected %s to be quarantined", first)
+	}
+	for _, c := range pool.ready {
+		if pool.keyOf[c] == first {
+			t.Errorf("client for a quarantined key is still ready")
+		}
+	}
+	if stats := pool.Stats(); stats.Quarantined != 2 || stats.CheckedOut != 0 {
+		t.Errorf("expected 2 quarantined clients and none checked out, got %+v", stats)
+	}
+
+	failKey()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := pool.GetAPIClientContext(ctx); err != ErrAllClientsQuarantined {
+		t.Errorf("expected ErrAllClientsQuarantined, got %v", err)
+	}
+}
+
+func TestIsKeyErrorOnlyMatchesStatuses(t *testing.T) {
+	cases := []struct {
+		err      string
+		keyError bool
+	}{
+		{"request failed: status code: 401", true},
+		{"HTTP 403: forbidden", true},
+		{"429 Too Many Requests", true},
+		{"invalid api key", true},
+		{"order 4291 not found", false},
+		{"price 9401.25 outside the band", false},
+		{"insufficient balance for 0.403 btc", false},
+		{"status code: 500", false},
+	}
+	for _, c := range cases {
+		if isKeyError(fmt.Errorf(c.err)) != c.keyError {
+			t.Errorf("expected isKeyError(%q) to be %v", c.err, c.keyError)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// keyRole is what an API key is allowed to be used for. Keys are labelled in the secret payload
+// with a role prefix, e.g. "read:abc123,trade:def456". Unlabelled keys are used for both.
+type keyRole string
+
+const (
+	KeyRoleRead  keyRole = "read"  // balances and order status
+	KeyRoleTrade keyRole = "trade" // placing and canceling orders
+)
+
+var keyRoles = []keyRole{KeyRoleRead, KeyRoleTrade}
+
+type APIKey struct {
+	Key   string
+	Roles []keyRole
+}
+
+func (k APIKey) HasRole(role keyRole) bool {
+	for _, r := range k.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Labelled is the key with its role prefix, the way it's written in the secret. It includes the key itself, never log it.
+func (k APIKey) Labelled() string {
+	if len(k.Roles) == 1 {
+		return string(k.Roles[0]) + ":" + k.Key
+	}
+	return k.Key
+}
+
+// String identifies the key without giving it away, so printing an APIKey or a config holding one is safe
+func (k APIKey) String() string {
+	if len(k.Roles) == 1 {
+		return string(k.Roles[0]) + ":" + keyHint(k.Key)
+	}
+	return keyHint(k.Key)
+}
+
+func parseAPIKey(labelled string) (APIKey, error) {
+	i := strings.Index(labelled, ":")
+	if i < 0 {
+		return APIKey{Key: labelled, Roles: keyRoles}, nil
+	}
+	role, key := keyRole(strings.TrimSpace(labelled[:i])), strings.TrimSpace(labelled[i+1:])
+	if role != KeyRoleRead && role != KeyRoleTrade {
+		// the key itself isn't included, the error ends up in logs
+		return APIKey{}, fmt.Errorf("unknown key role %q, expected read or trade", string(role))
+	}
+	if key == "" {
+		return APIKey{}, fmt.Errorf("empty key labelled %q", string(role))
+	}
+	return APIKey{Key: key, Roles: []keyRole{role}}, nil
+}
+
+// keysForRole returns the keys that can be used for role
+func keysForRole(keys []APIKey, role keyRole) (ret []string) {
+	for _, k := range keys {
+		if k.HasRole(role) {
+			ret = append(ret, k.Key)
+		}
+	}
+	return ret
+}
+
+// validateKeyRoles makes sure every role has at least one key to use
+func validateKeyRoles(keys []APIKey) error {
+	for _, role := range keyRoles {
+		if len(keysForRole(keys, role)) == 0 {
+			return fmt.Errorf("no API keys can be used for %s, label at least one key %q or leave one unlabelled", role, string(role)+":")
+		}
+	}
+	return nil
+}
@@ -5,48 +5,79 @@ import (
 	"log"
 	"net/url"
 	"os"
+	"time"
 
 	tc "github.com/ldcicconi/trading-common"
-	ws "github.com/ldcicconi/ws-contractor"
 )
 
 type app struct {
-	md            *marketData
+	logger        *log.Logger
+	md            MarketDataSource
 	tm            *traderManager
-	rawDataChan   chan ws.MessageEnvelope
-	orderbookChan chan tc.SFOXOrderbook
+	orderbookChan chan sfoxBook
 }
 
-func NewApp(wsURL url.URL, wsSubMessage interface{}, wsIsSecure bool, sfoxAPIKeys []string, pairsStr []string) *app {
+func NewApp(wsURL url.URL, wsSubMessage interface{}, wsIsSecure bool, sfoxAPIKeys []APIKey, pairsStr []string) *app {
 	subMessageBytes, _ := json.Marshal(wsSubMessage)
 	// pairs := GetPairsFromPairStrings(pairsStr)
 	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime|log.Lmicroseconds)
+	tm := NewTraderManager(logger, sfoxAPIKeys, nil, DefaultClientPoolsConfig(), DefaultRateLimitsConfig())
+	tm.liveOrders = true
 	return &app{
+		logger:        logger,
 		md:            NewMarketData(wsURL, subMessageBytes, wsIsSecure, logger),
-		tm:            NewTraderManager(logger, sfoxAPIKeys, nil),
-		rawDataChan:   make(chan ws.MessageEnvelope),
-		orderbookChan: make(chan tc.SFOXOrderbook),
+		tm:            tm,
+		orderbookChan: make(chan sfoxBook),
 	}
 
 }
 
-func NewSFOXArbApp(pairConfigs []TraderConfig, SFOXAPIKeys []string) *app {
+func NewSFOXArbApp(config *Config, SFOXAPIKeys []APIKey) (*app, error) {
 	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime|log.Lmicroseconds)
 	var pairs []tc.Pair
-	for _, tc := range pairConfigs {
+	for _, tc := range config.Traders {
 		pairs = append(pairs, tc.Pair)
 	}
-	return &app{
-		md:            NewSFOXMarketData(SFOXURL, pairs, logger),
-		tm:            NewTraderManager(logger, SFOXAPIKeys, pairConfigs),
-		rawDataChan:   make(chan ws.MessageEnvelope),
-		orderbookChan: make(chan tc.SFOXOrderbook),
+	md, err := NewMarketDataSource(config.MarketData, pairs, logger)
+	if err != nil {
+		return nil, err
+	}
+	tm := NewTraderManager(logger, SFOXAPIKeys, config.Traders, config.ClientPools, config.RateLimits)
+	tm.liveOrders = config.MarketData.Source == MarketDataSourceWebsocket
+	if config.MarketData.Simulated() {
+		logger.Println("[app] [info] market data source is " + config.MarketData.Source + ", orders go to the simulated venue")
+		tm.simulatedVenue = NewSimulatedVenue(config.Simulation, logger)
 	}
+	tm.feedStatus = md.Status
+	tm.unknownPairs = NewUnknownPairs(config.UnknownPairs)
+	tm.latency = NewLatencyStats(config.Latency)
+	return &app{
+		logger:        logger,
+		md:            md,
+		tm:            tm,
+		orderbookChan: make(chan sfoxBook),
+	}, nil
 }
 
 func (a *app) Start() {
 	// start the marketdata service
-	a.md.Start(a.rawDataChan, a.orderbookChan)
+	a.md.Start(a.orderbookChan)
 	// start the traders
 	a.tm.Start(a.orderbookChan)
 }
+
+// WatchConfig reloads the pairs and their limits from the config file at path whenever it changes or the process gets SIGHUP
+func (a *app) WatchConfig(path string) {
+	NewConfigReloader(path, 5*time.Second, a.ApplyConfig, a.logger).Start()
+}
+
+func (a *app) ApplyConfig(config *Config) {
+	if a.tm.UpdateTraderConfigs(config.Traders) {
+		a.md.SetPairs(a.tm.Pairs())
+	}
+}
+
+// WatchCredentials keeps the client pools' keys in sync with the credential source
+func (a *app) WatchCredentials(provider CredentialProvider, interval time.Duration, initialKeys []APIKey) {
+	NewKeyRotator(provider, interval, a.tm.UpdateAPIKeys, initialKeys, a.logger).Start()
+}
@@ -47,6 +47,11 @@ const (
 // TODO: needs to be tested
 func FindArb(inOb tc.SFOXOrderbook, limits TradeLimits, availableQuoteBalance decimal.Decimal) (arb arbStrat, err error) {
 	o := inOb.MakeCopy()
+	// staleness checks and venue rules can drop a whole side, Arb reads the top of both
+	if len(o.Bids) == 0 || len(o.Asks) == 0 {
+		err = errNoArb
+		return
+	}
 	priceArb := o.Arb()
 	if priceArb.LessThanOrEqual(decimal.Zero) {
 		err = errNoArb
@@ -1,24 +1,25 @@
 package main
 
 import (
-	"strings"
-
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
 )
 
-var awsSFOXAPIKeysID = "sfox-keys"
+type awsSecretsCredentialProvider struct {
+	secretID string
+	region   string
+}
 
-func getAPIKeysFromAWSSecrets() ([]string, error) {
+func (p *awsSecretsCredentialProvider) APIKeys() ([]APIKey, error) {
 	awsSession := session.Must(session.NewSession())
-	awsClient := secretsmanager.New(awsSession, aws.NewConfig().WithRegion("us-east-2"))
+	awsClient := secretsmanager.New(awsSession, aws.NewConfig().WithRegion(p.region))
 	secretRequest := secretsmanager.GetSecretValueInput{
-		SecretId: &awsSFOXAPIKeysID,
+		SecretId: &p.secretID,
 	}
 	response, err := awsClient.GetSecretValue(&secretRequest)
 	if err != nil {
 		return nil, err
 	}
-	return strings.Split(*response.SecretString, ","), nil
+	return parseAPIKeys(*response.SecretString, "secret "+p.secretID)
 }
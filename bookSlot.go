@@ -0,0 +1,58 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// bookSlot holds the latest book for a pair that its trader hasn't picked up yet. A new book replaces
+// one that's still waiting, so a slow trader skips straight to the newest book instead of working
+// through a backlog, and routing never waits on a trader.
+type bookSlot struct {
+	dropped uint64 // books replaced before the trader got to them, accessed atomically
+	book    sfoxBook
+	pending bool
+	ready   chan struct{} // holds a value whenever a book may be pending
+	lock    sync.Mutex
+}
+
+func NewBookSlot() *bookSlot {
+	return &bookSlot{
+		ready: make(chan struct{}, 1),
+	}
+}
+
+// Put never blocks
+func (s *bookSlot) Put(b sfoxBook) {
+	s.lock.Lock()
+	if s.pending {
+		atomic.AddUint64(&s.dropped, 1)
+	}
+	s.book, s.pending = b, true
+	s.lock.Unlock()
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Take returns the pending book, if there is one
+func (s *bookSlot) Take() (sfoxBook, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.pending {
+		return sfoxBook{}, false
+	}
+	b := s.book
+	s.book, s.pending = sfoxBook{}, false
+	return b, true
+}
+
+// Ready has a value whenever Take might return a book
+func (s *bookSlot) Ready() <-chan struct{} {
+	return s.ready
+}
+
+func (s *bookSlot) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	tc "github.com/ldcicconi/trading-common"
+)
+
+func TestBookSlotKeepsTheLatestBook(t *testing.T) {
+	s := NewBookSlot()
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		s.Put(sfoxBook{SFOXOrderbook: tc.SFOXOrderbook{SFOXTimestamp: start.Add(time.Duration(i) * time.Second)}})
+	}
+	select {
+	case <-s.Ready():
+	default:
+		t.Fatalf("expected the slot to be ready")
+	}
+	b, ok := s.Take()
+	if !ok || !b.SFOXTimestamp.Equal(start.Add(2*time.Second)) {
+		t.Errorf("expected the newest book, got %v", b.SFOXTimestamp)
+	}
+	if s.Dropped() != 2 {
+		t.Errorf("expected 2 dropped books, got %d", s.Dropped())
+	}
+	if _, ok := s.Take(); ok {
+		t.Errorf("expected the slot to be empty")
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	ws "github.com/ldcicconi/ws-contractor"
+)
+
+// captureRecorder writes every raw feed message, with the time we received it, to gzipped JSONL files
+// rotated every hour (UTC). Each line looks like
+//
+//	{"receiptTimestamp":1572925018490923000,"message":{"sequence":5,"recipient":"orderbook.sfox.btcusd",...}}
+//
+// Messages are handed off on a buffered channel and written on the recorder's own goroutine,
+// so the trading path never waits on the disk. If the buffer fills up, messages are dropped and counted.
+type captureRecorder struct {
+	dropped  uint64 // accessed atomically, keep it first for alignment
+	invalid  uint64
+	config   RecorderConfig
+	messages chan ws.MessageEnvelope
+	file     *os.File
+	gz       *gzip.Writer
+	buf      *bufio.Writer
+	fileHour time.Time
+	Logger   *log.Logger
+}
+
+type RecorderConfig struct {
+	Dir        string   `json:"dir"` // recording is off unless this is set
+	BufferSize int      `json:"bufferSize"`
+	MaxFiles   int      `json:"maxFiles"` // oldest captures are deleted past this many, 0 keeps them all
+	MaxAge     Duration `json:"maxAge"`   // captures older than this are deleted, 0 keeps them forever
+}
+
+func DefaultRecorderConfig() RecorderConfig {
+	return RecorderConfig{
+		BufferSize: 10000,
+		MaxFiles:   24 * 7,
+	}
+}
+
+func (c RecorderConfig) validate() (errs []error) {
+	if c.Dir == "" {
+		return nil
+	}
+	if c.BufferSize <= 0 {
+		errs = append(errs, fmt.Errorf("marketData.record: bufferSize must be greater than 0"))
+	}
+	if c.MaxFiles < 0 || c.MaxAge.Duration < 0 {
+		errs = append(errs, fmt.Errorf("marketData.record: maxFiles and maxAge must not be negative"))
+	}
+	return errs
+}
+
+const (
+	captureFilePrefix = "sfox-"
+	captureFileSuffix = ".jsonl.gz"
+)
+
+func NewCaptureRecorder(config RecorderConfig, logger *log.Logger) *captureRecorder {
+	return &captureRecorder{
+		config:   config,
+		messages: make(chan ws.MessageEnvelope, config.BufferSize),
+		Logger:   logger,
+	}
+}
+
+func (r *captureRecorder) Start() error {
+	if err := os.MkdirAll(r.config.Dir, 0755); err != nil {
+		return err
+	}
+	go r.run()
+	return nil
+}
+
+// Record never blocks, it's called for every message on the trading path. A nil recorder records nothing.
+func (r *captureRecorder) Record(msg ws.MessageEnvelope) {
+	if r == nil {
+		return
+	}
+	select {
+	case r.messages <- msg:
+	default:
+		atomic.AddUint64(&r.dropped, 1)
+	}
+}
+
+func (r *captureRecorder) Dropped() uint64 {
+	return atomic.LoadUint64(&r.dropped)
+}
+
+func (r *captureRecorder) run() {
+	// flush every second so a crash loses at most a second of data
+	flush := time.NewTicker(time.Second)
+	defer flush.Stop()
+	report := time.NewTicker(time.Minute)
+	defer report.Stop()
+	var reportedDropped, reportedInvalid uint64
+	for {
+		select {
+		case msg := <-r.messages:
+			if err := r.write(msg); err != nil {
+				r.LogInfo("ERROR writing capture: " + err.Error())
+			}
+		case <-flush.C:
+			if r.buf != nil {
+				if err := r.flush(); err != nil {
+					r.LogInfo("ERROR flushing capture: " + err.Error())
+				}
+			}
+		case <-report.C:
+			dropped, invalid := r.Dropped(), atomic.LoadUint64(&r.invalid)
+			if dropped > reportedDropped || invalid > reportedInvalid {
+				r.LogInfo(fmt.Sprintf("%d messages dropped because the buffer was full, %d skipped as invalid JSON, since startup", dropped, invalid))
+				reportedDropped, reportedInvalid = dropped, invalid
+			}
+		}
+	}
+}
+
+func (r *captureRecorder) write(msg ws.MessageEnvelope) error {
+	if !json.Valid(msg.Payload) {
+		atomic.AddUint64(&r.invalid, 1)
+		return nil
+	}
+	hour := msg.ReceiptTimestamp.UTC().Truncate(time.Hour)
+	if r.buf == nil || !hour.Equal(r.fileHour) {
+		if err := r.rotate(hour); err != nil {
+			return err
+		}
+	}
+	r.buf.WriteString(`{"receiptTimestamp":`)
+	r.buf.WriteString(strconv.FormatInt(msg.ReceiptTimestamp.UnixNano(), 10))
+	r.buf.WriteString(`,"message":`)
+	r.buf.Write(msg.Payload)
+	_, err := r.buf.WriteString("}\n")
+	return err
+}
+
+// rotate closes the current file and opens the one for hour. If that file already exists (say after a
+// restart) it's appended to, gzip readers handle the concatenated streams.
+func (r *captureRecorder) rotate(hour time.Time) error {
+	if err := r.close(); err != nil {
+		r.LogInfo("ERROR closing capture: " + err.Error())
+	}
+	path := filepath.Join(r.config.Dir, captureFilePrefix+hour.Format("20060102-15")+captureFileSuffix)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	r.file = f
+	r.gz = gzip.NewWriter(f)
+	r.buf = bufio.NewWriterSize(r.gz, 256*1024)
+	r.fileHour = hour
+	r.LogInfo("recording to " + path)
+	r.enforceRetention()
+	return nil
+}
+
+func (r *captureRecorder) flush() error {
+	if err := r.buf.Flush(); err != nil {
+		return err
+	}
+	return r.gz.Flush()
+}
+
+func (r *captureRecorder) close() error {
+	if r.file == nil {
+		return nil
+	}
+	defer func() {
+		r.file, r.gz, r.buf = nil, nil, nil
+	}()
+	if err := r.buf.Flush(); err != nil {
+		r.file.Close()
+		return err
+	}
+	if err := r.gz.Close(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+// enforceRetention deletes old captures, never the one being written
+func (r *captureRecorder) enforceRetention() {
+	infos, err := ioutil.ReadDir(r.config.Dir)
+	if err != nil {
+		r.LogInfo("ERROR listing captures: " + err.Error())
+		return
+	}
+	var captures []os.FileInfo
+	for _, info := range infos {
+		if !info.IsDir() && strings.HasPrefix(info.Name(), captureFilePrefix) && strings.HasSuffix(info.Name(), captureFileSuffix) {
+			captures = append(captures, info)
+		}
+	}
+	// the names sort in time order, oldest first
+	sort.Slice(captures, func(i, j int) bool { return captures[i].Name() < captures[j].Name() })
+	current := filepath.Base(r.file.Name())
+	for i, info := range captures {
+		tooMany := r.config.MaxFiles > 0 && len(captures)-i > r.config.MaxFiles
+		tooOld := r.config.MaxAge.Duration > 0 && time.Since(info.ModTime()) > r.config.MaxAge.Duration
+		if info.Name() == current || !(tooMany || tooOld) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(r.config.Dir, info.Name())); err != nil {
+			r.LogInfo("ERROR deleting old capture: " + err.Error())
+			continue
+		}
+		r.LogInfo("deleted old capture " + info.Name())
+	}
+}
+
+func (r *captureRecorder) LogInfo(text string) {
+	r.Logger.Println("[recorder] [info] " + text)
+}
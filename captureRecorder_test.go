@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ws "github.com/ldcicconi/ws-contractor"
+)
+
+func TestCaptureRecorderRotatesHourly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "captures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	r := NewCaptureRecorder(RecorderConfig{Dir: dir, BufferSize: 10, MaxFiles: 2}, testLogger)
+	start := time.Date(2019, 11, 5, 3, 59, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		msg := ws.MessageEnvelope{
+			Payload:          []byte(`{"sequence":1,"recipient":"orderbook.sfox.btcusd"}`),
+			ReceiptTimestamp: start.Add(time.Duration(i) * time.Hour),
+		}
+		if err := r.write(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.write(ws.MessageEnvelope{Payload: []byte("not json"), ReceiptTimestamp: start.Add(2 * time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.close(); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 2 {
+		t.Fatalf("expected retention to keep 2 files, got %v", files)
+	}
+	f, err := os.Open(filepath.Join(dir, "sfox-20191105-05.jsonl.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type captureLine struct {
+		ReceiptTimestamp int64           `json:"receiptTimestamp"`
+		Message          json.RawMessage `json:"message"`
+	}
+	var lines []captureLine
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		var line captureLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("bad line %q: %s", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 1 || lines[0].ReceiptTimestamp != start.Add(2*time.Hour).UnixNano() {
+		t.Errorf("unexpected capture contents: %+v", lines)
+	}
+	if r.invalid != 1 {
+		t.Errorf("expected the invalid message to be counted, got %d", r.invalid)
+	}
+}
+
+func TestCaptureRecorderDropsWhenFull(t *testing.T) {
+	r := NewCaptureRecorder(RecorderConfig{Dir: "unused", BufferSize: 1}, testLogger)
+	r.Record(ws.MessageEnvelope{})
+	r.Record(ws.MessageEnvelope{})
+	if r.Dropped() != 1 {
+		t.Errorf("expected 1 dropped message, got %d", r.Dropped())
+	}
+	var nilRecorder *captureRecorder
+	nilRecorder.Record(ws.MessageEnvelope{})
+}
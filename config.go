@@ -1,6 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
 	tc "github.com/ldcicconi/trading-common"
 	"github.com/shopspring/decimal"
 )
@@ -8,6 +15,8 @@ import (
 type TraderConfig struct {
 	Pair tc.Pair
 	TradeLimits
+	Venues      VenueRules
+	MonitorOnly bool // logs the arbs it finds without entering them, see UnknownPairsConfig
 }
 
 type TradeLimits struct {
@@ -25,3 +34,260 @@ func NewTraderConfig(pair tc.Pair, limits TradeLimits) *TraderConfig {
 		TradeLimits: limits,
 	}
 }
+
+func (l TradeLimits) Equal(other TradeLimits) bool {
+	for _, f := range tradeLimitFields {
+		if !f.field(&l).Equal(*f.field(&other)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Config is everything the app reads from its config file at startup
+type Config struct {
+	Traders      []TraderConfig
+	Credentials  CredentialsConfig
+	ClientPools  ClientPoolsConfig
+	RateLimits   RateLimitsConfig
+	MarketData   MarketDataConfig
+	Simulation   SimulationConfig
+	UnknownPairs UnknownPairsConfig
+	Latency      LatencyConfig
+}
+
+// configFile mirrors the on-disk layout. Limits are kept as raw key/value maps so that
+// pairs only need to list the fields they override, and so errors can name the field.
+// Each pair's limits are layered, later layers winning:
+// the built-in defaults for its quote currency (see pairClass), defaults, quoteDefaults, then the pair itself.
+//
+//	{
+//	  "defaults": {"maxOrderQuantity": "100", "profitThresholdBps": "12", ...},
+//	  "quoteDefaults": {
+//	    "btc": {"maxOrderAmount": "0.01"}
+//	  },
+//	  "pairs": [
+//	    {"pair": "btcusd", "maxOrderQuantity": "1"},
+//	    {"pair": "ethbtc", "profitThresholdBps": "15"}
+//	  ]
+//	}
+type configFile struct {
+	Defaults      map[string]interface{}            `json:"defaults"`
+	QuoteDefaults map[string]map[string]interface{} `json:"quoteDefaults"`
+	Pairs         []map[string]interface{}          `json:"pairs"`
+	Credentials   CredentialsConfig                 `json:"credentials"`
+	ClientPools   ClientPoolsConfig                 `json:"clientPools"`
+	RateLimits    RateLimitsConfig                  `json:"rateLimits"`
+	MarketData    MarketDataConfig                  `json:"marketData"`
+	Simulation    SimulationConfig                  `json:"simulation"`
+	VenueRules    VenueRulesConfig                  `json:"venueRules"`
+	UnknownPairs  UnknownPairsConfig                `json:"unknownPairs"`
+	Latency       LatencyConfig                     `json:"latency"`
+}
+
+// tradeLimitFields maps config file keys to the TradeLimits field they set.
+// Fields denominated in the quote currency can't be set in the shared defaults, since they'd apply to every quote currency.
+var tradeLimitFields = []struct {
+	name          string
+	quoteCurrency bool
+	field         func(*TradeLimits) *decimal.Decimal
+}{
+	{"minOrderQuantity", false, func(l *TradeLimits) *decimal.Decimal { return &l.MinOrderQuantity }},
+	{"maxOrderQuantity", false, func(l *TradeLimits) *decimal.Decimal { return &l.MaxOrderQuantity }},
+	{"minOrderAmount", true, func(l *TradeLimits) *decimal.Decimal { return &l.MinOrderAmount }},
+	{"maxOrderAmount", true, func(l *TradeLimits) *decimal.Decimal { return &l.MaxOrderAmount }},
+	{"profitThresholdBps", false, func(l *TradeLimits) *decimal.Decimal { return &l.ProfitThresholdBps }},
+	{"feeRateBps", false, func(l *TradeLimits) *decimal.Decimal { return &l.FeeRateBps }},
+}
+
+// LoadConfig reads the config file at path. Any missing or malformed field fails the whole load.
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config: %s", err.Error())
+	}
+	return ParseConfig(data)
+}
+
+func ParseConfig(data []byte) (*Config, error) {
+	file := configFile{
+		Credentials:  DefaultCredentialsConfig(),
+		ClientPools:  DefaultClientPoolsConfig(),
+		RateLimits:   DefaultRateLimitsConfig(),
+		MarketData:   DefaultMarketDataConfig(),
+		Simulation:   DefaultSimulationConfig(),
+		UnknownPairs: DefaultUnknownPairsConfig(),
+		Latency:      DefaultLatencyConfig(),
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("config: malformed file: %s", err.Error())
+	}
+	if len(file.Pairs) == 0 {
+		return nil, fmt.Errorf("config: pairs: at least one pair must be configured")
+	}
+	config := &Config{
+		Credentials:  file.Credentials,
+		ClientPools:  file.ClientPools,
+		RateLimits:   file.RateLimits,
+		MarketData:   file.MarketData,
+		Simulation:   file.Simulation,
+		UnknownPairs: file.UnknownPairs,
+		Latency:      file.Latency,
+	}
+	var errs ConfigErrors
+	errs = append(errs, file.Credentials.validate()...)
+	errs = append(errs, file.ClientPools.validate()...)
+	errs = append(errs, file.RateLimits.validate()...)
+	errs = append(errs, file.MarketData.validate()...)
+	errs = append(errs, file.Simulation.validate()...)
+	errs = append(errs, file.UnknownPairs.validate()...)
+	errs = append(errs, file.Latency.validate()...)
+	for key := range file.Defaults {
+		if !isTradeLimitField(key) {
+			errs = append(errs, fmt.Errorf("defaults: unknown field %q", key))
+		} else if isQuoteCurrencyField(key) {
+			errs = append(errs, fmt.Errorf("defaults: %s is denominated in the quote currency, set it in quoteDefaults or on the pair", key))
+		}
+	}
+	for quote, limits := range file.QuoteDefaults {
+		if _, ok := pairClasses[quote]; !ok {
+			errs = append(errs, fmt.Errorf("quoteDefaults: unsupported quote currency %q", quote))
+		}
+		for key := range limits {
+			if !isTradeLimitField(key) {
+				errs = append(errs, fmt.Errorf("quoteDefaults.%s: unknown field %q", quote, key))
+			}
+		}
+	}
+	seen := make(map[tc.Pair]bool)
+	for i, entry := range file.Pairs {
+		pairStr, ok := entry["pair"].(string)
+		if !ok || pairStr == "" {
+			errs = append(errs, fmt.Errorf("pairs[%d]: pair is required and must be a string", i))
+			continue
+		}
+		pair := *tc.NewPair(strings.ToLower(pairStr))
+		if seen[pair] {
+			errs = append(errs, fmt.Errorf("pairs[%d] (%s): pair is configured more than once", i, pairStr))
+			continue
+		}
+		seen[pair] = true
+		class, err := pairClassOf(pair)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("pairs[%d] (%s): %s", i, pairStr, err.Error()))
+			continue
+		}
+		limits, limitErrs := parseTradeLimits(class.defaultLimits(), file.Defaults, file.QuoteDefaults[class.Quote], entry)
+		for _, err := range limitErrs {
+			errs = append(errs, fmt.Errorf("pairs[%d] (%s): %s", i, pairStr, err.Error()))
+		}
+		if len(limitErrs) > 0 {
+			continue
+		}
+		trader := NewTraderConfig(pair, limits)
+		trader.Venues = file.VenueRules.forPair(pair.String())
+		config.Traders = append(config.Traders, *trader)
+	}
+	errs = append(errs, file.VenueRules.validate(seen)...)
+	if file.UnknownPairs.Monitor {
+		config.UnknownPairs.limits = make(map[string]TradeLimits)
+		for quote, class := range pairClasses {
+			limits, limitErrs := parseTradeLimits(class.defaultLimits(), file.Defaults, file.QuoteDefaults[quote], map[string]interface{}{})
+			for _, err := range limitErrs {
+				errs = append(errs, fmt.Errorf("unknownPairs: monitoring %s pairs: %s", quote, err.Error()))
+			}
+			config.UnknownPairs.limits[quote] = limits
+		}
+	}
+	if err := ValidateTraderConfigs(config.Traders); err != nil {
+		errs = append(errs, err.(ConfigErrors)...)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return config, nil
+}
+
+// parseTradeLimits stacks the layers in order, later layers winning, and requires every limit to end up set.
+// The last layer is the pair's own entry.
+func parseTradeLimits(layers ...map[string]interface{}) (limits TradeLimits, errs []error) {
+	overrides := layers[len(layers)-1]
+	for key := range overrides {
+		if key != "pair" && !isTradeLimitField(key) {
+			errs = append(errs, fmt.Errorf("unknown field %q", key))
+		}
+	}
+	for _, f := range tradeLimitFields {
+		var raw interface{}
+		ok := false
+		for i := len(layers) - 1; i >= 0 && !ok; i-- {
+			raw, ok = layers[i][f.name]
+		}
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s is not set in the pair or in defaults", f.name))
+			continue
+		}
+		value, err := parseConfigDecimal(raw)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", f.name, err.Error()))
+			continue
+		}
+		*f.field(&limits) = value
+	}
+	return limits, errs
+}
+
+func isTradeLimitField(name string) bool {
+	for _, f := range tradeLimitFields {
+		if f.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func isQuoteCurrencyField(name string) bool {
+	for _, f := range tradeLimitFields {
+		if f.name == name {
+			return f.quoteCurrency
+		}
+	}
+	return false
+}
+
+// Duration lets durations be written as strings like "30s" or "5m" in the config file
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("durations must be strings like \"30s\", got %s", string(data))
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+// parseConfigDecimal accepts both JSON numbers and strings, so "17.5" and 17.5 are equivalent
+func parseConfigDecimal(raw interface{}) (decimal.Decimal, error) {
+	switch v := raw.(type) {
+	case json.Number:
+		return decimal.NewFromString(v.String())
+	case string:
+		d, err := decimal.NewFromString(v)
+		if err != nil {
+			return d, fmt.Errorf("invalid number %q", v)
+		}
+		return d, nil
+	default:
+		return decimal.Zero, fmt.Errorf("expected a number, got %v", raw)
+	}
+}
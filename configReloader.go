@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// configReloader re-reads the config file on SIGHUP, or when the file's modification time changes,
+// and hands valid configs to apply. An invalid file is logged and the running config is kept.
+type configReloader struct {
+	path         string
+	pollInterval time.Duration
+	apply        func(*Config)
+	Logger       *log.Logger
+	lastModTime  time.Time
+}
+
+func NewConfigReloader(path string, pollInterval time.Duration, apply func(*Config), logger *log.Logger) *configReloader {
+	r := &configReloader{
+		path:         path,
+		pollInterval: pollInterval,
+		apply:        apply,
+		Logger:       logger,
+	}
+	if info, err := os.Stat(path); err == nil {
+		r.lastModTime = info.ModTime()
+	}
+	return r
+}
+
+func (r *configReloader) Start() {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		ticker := time.NewTicker(r.pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-hangups:
+				r.Logger.Println("[config] [info] SIGHUP received, reloading " + r.path)
+				r.reload()
+			case <-ticker.C:
+				if r.fileChanged() {
+					r.Logger.Println("[config] [info] " + r.path + " changed, reloading")
+					r.reload()
+				}
+			}
+		}
+	}()
+}
+
+func (r *configReloader) fileChanged() bool {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return false
+	}
+	if info.ModTime().Equal(r.lastModTime) {
+		return false
+	}
+	r.lastModTime = info.ModTime()
+	return true
+}
+
+func (r *configReloader) reload() {
+	config, err := LoadConfig(r.path)
+	if err != nil {
+		r.Logger.Println("[config] [error] keeping the current config, reload failed: " + err.Error())
+		return
+	}
+	r.apply(config)
+}
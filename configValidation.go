@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// ConfigErrors collects every problem found in a config so they can all be reported at once
+type ConfigErrors []error
+
+func (errs ConfigErrors) Error() string {
+	lines := make([]string, 0, len(errs))
+	for _, err := range errs {
+		lines = append(lines, "  - "+err.Error())
+	}
+	return fmt.Sprintf("config: %d problem(s):\n%s", len(errs), strings.Join(lines, "\n"))
+}
+
+// ValidateTraderConfigs checks every config and returns all violations together as ConfigErrors
+func ValidateTraderConfigs(configs []TraderConfig) error {
+	var errs ConfigErrors
+	for _, c := range configs {
+		for _, err := range c.validate() {
+			errs = append(errs, fmt.Errorf("%s: %s", c.Pair.String(), err.Error()))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (c TraderConfig) validate() (errs []error) {
+	errs = c.Venues.validate()
+	l := c.TradeLimits
+	class, err := pairClassOf(c.Pair)
+	if err != nil {
+		errs = append(errs, err)
+	} else {
+		if l.MinOrderQuantity.LessThan(class.MinOrderQuantity) {
+			errs = append(errs, fmt.Errorf("minOrderQuantity %s is below the SFOX minimum of %s", l.MinOrderQuantity, class.MinOrderQuantity))
+		}
+		if l.MinOrderAmount.LessThan(class.MinOrderAmount) {
+			errs = append(errs, fmt.Errorf("minOrderAmount %s is below the SFOX minimum of %s", l.MinOrderAmount, class.FormatAmount(class.MinOrderAmount)))
+		}
+	}
+	if !l.MaxOrderQuantity.GreaterThan(decimal.Zero) {
+		errs = append(errs, fmt.Errorf("maxOrderQuantity must be greater than 0, got %s", l.MaxOrderQuantity))
+	}
+	if !l.MaxOrderAmount.GreaterThan(decimal.Zero) {
+		errs = append(errs, fmt.Errorf("maxOrderAmount must be greater than 0, got %s", l.MaxOrderAmount))
+	}
+	if l.MinOrderQuantity.GreaterThan(l.MaxOrderQuantity) {
+		errs = append(errs, fmt.Errorf("minOrderQuantity %s is greater than maxOrderQuantity %s", l.MinOrderQuantity, l.MaxOrderQuantity))
+	}
+	if l.MinOrderAmount.GreaterThan(l.MaxOrderAmount) {
+		errs = append(errs, fmt.Errorf("minOrderAmount %s is greater than maxOrderAmount %s", l.MinOrderAmount, l.MaxOrderAmount))
+	}
+	if l.FeeRateBps.LessThan(decimal.Zero) {
+		errs = append(errs, fmt.Errorf("feeRateBps must not be negative, got %s", l.FeeRateBps))
+	}
+	if l.ProfitThresholdBps.LessThan(decimal.Zero) {
+		errs = append(errs, fmt.Errorf("profitThresholdBps must not be negative, got %s", l.ProfitThresholdBps))
+	}
+	return errs
+}
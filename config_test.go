@@ -0,0 +1,114 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	tc "github.com/ldcicconi/trading-common"
+	"github.com/shopspring/decimal"
+)
+
+var testConfigFile = `{
+	"defaults": {
+		"minOrderQuantity": "0.001",
+		"maxOrderQuantity": 100,
+		"profitThresholdBps": "12",
+		"feeRateBps": "17.5"
+	},
+	"quoteDefaults": {
+		"usd": {"minOrderAmount": "5", "maxOrderAmount": "50"}
+	},
+	"pairs": [
+		{"pair": "btcusd", "maxOrderQuantity": "1"},
+		{"pair": "ethusd"},
+		{"pair": "ethbtc"}
+	]
+}`
+
+func TestParseConfigOverridesDefaults(t *testing.T) {
+	config, err := ParseConfig([]byte(testConfigFile))
+	if err != nil {
+		t.Fatalf("unexpected error parsing config: %s", err.Error())
+	}
+	if len(config.Traders) != 3 {
+		t.Fatalf("expected 3 traders, got %d", len(config.Traders))
+	}
+	btc := config.Traders[0]
+	if btc.Pair != *tc.NewPair("btcusd") || !btc.MaxOrderQuantity.Equal(decimal.New(1, 0)) {
+		t.Errorf("btcusd override not applied: %+v", btc)
+	}
+	eth := config.Traders[1]
+	if !eth.MaxOrderQuantity.Equal(decimal.New(100, 0)) || !eth.FeeRateBps.Equal(decimal.New(175, -1)) {
+		t.Errorf("ethusd did not inherit defaults: %+v", eth)
+	}
+	ethbtc := config.Traders[2]
+	if !ethbtc.MinOrderAmount.Equal(BTCQuotePairMinAmount) || !ethbtc.MaxOrderAmount.Equal(BTCQuotePairMaxAmount) {
+		t.Errorf("ethbtc did not get the btc quoted pair defaults: %+v", ethbtc)
+	}
+}
+
+func TestParseConfigErrorsNameTheField(t *testing.T) {
+	cases := []struct {
+		file  string
+		field string
+	}{
+		{`{"pairs": [{"pair": "btcusd"}]}`, "maxOrderQuantity"},
+		{strings.Replace(testConfigFile, `"17.5"`, `"abc"`, 1), "feeRateBps"},
+		{strings.Replace(testConfigFile, `{"pair": "ethusd"}`, `{"pair": "ethusd", "maxOrderAmnt": "1"}`, 1), "maxOrderAmnt"},
+		{`{"pairs": [{"pair": "btcusd"}]`, "malformed"},
+		{strings.Replace(testConfigFile, `"feeRateBps": "17.5"`, `"feeRateBps": "17.5", "maxOrderAmount": "50"`, 1), "quote currency"},
+		{strings.Replace(testConfigFile, `"ethbtc"`, `"etheur"`, 1), "unsupported quote currency"},
+		{strings.Replace(testConfigFile, `"pairs"`, `"credentials": {"source": "vault"}, "pairs"`, 1), "unknown source"},
+		{strings.Replace(testConfigFile, `"pairs"`, `"credentials": {"source": "file"}, "pairs"`, 1), "file is required"},
+		{strings.Replace(testConfigFile, `"pairs"`, `"marketData": {"source": "kafka"}, "pairs"`, 1), "unknown source"},
+		{strings.Replace(testConfigFile, `"pairs"`, `"marketData": {"source": "replay"}, "pairs"`, 1), "marketData.replay: file is required"},
+		{strings.Replace(testConfigFile, `"pairs"`, `"marketData": {"source": "replay", "replay": {"file": "x.jsonl", "timing": "slow"}}, "pairs"`, 1), "unknown timing"},
+		{strings.Replace(testConfigFile, `"pairs"`, `"marketData": {"staleness": {"action": "ignore"}}, "pairs"`, 1), "unknown action"},
+		{strings.Replace(testConfigFile, `"pairs"`, `"marketData": {"health": {"staleAfter": "30s"}}, "pairs"`, 1), "idleTimeout must not be less than staleAfter"},
+		{strings.Replace(testConfigFile, `"pairs"`, `"simulation": {"balances": {"usd": -1}}, "pairs"`, 1), "balance for usd"},
+		{strings.Replace(testConfigFile, `"pairs"`, `"unknownPairs": {"logInterval": "0s"}, "pairs"`, 1), "unknownPairs: logInterval"},
+		{strings.Replace(testConfigFile, `"pairs"`, `"latency": {"window": 0}, "pairs"`, 1), "latency: window"},
+		{`{"unknownPairs": {"monitor": true}, "pairs": [{"pair": "btcusd", "maxOrderQuantity": "1", "profitThresholdBps": "12"}]}`, "unknownPairs: monitoring usd pairs: maxOrderQuantity"},
+	}
+	for _, c := range cases {
+		_, err := ParseConfig([]byte(c.file))
+		if err == nil {
+			t.Errorf("expected an error mentioning %s", c.field)
+			continue
+		}
+		if !strings.Contains(err.Error(), c.field) {
+			t.Errorf("expected error to mention %s, got: %s", c.field, err.Error())
+		}
+	}
+}
+
+func TestValidateTraderConfigsReportsEveryViolation(t *testing.T) {
+	bad := *NewTraderConfig(*tc.NewPair("btcusd"), TradeLimits{
+		MinOrderQuantity:   decimal.New(1, -4), // below the SFOX minimum
+		MaxOrderQuantity:   decimal.Zero,
+		MinOrderAmount:     decimal.New(100, 0),
+		MaxOrderAmount:     decimal.New(50, 0),
+		ProfitThresholdBps: decimal.New(12, 0),
+		FeeRateBps:         decimal.New(-1, 0),
+	})
+	err := ValidateTraderConfigs([]TraderConfig{bad})
+	errs, ok := err.(ConfigErrors)
+	if !ok {
+		t.Fatalf("expected ConfigErrors, got %v", err)
+	}
+	// quantity below minimum, zero max quantity, min quantity > max quantity, min amount > max amount, negative fee
+	if len(errs) != 5 {
+		t.Errorf("expected 5 violations, got %d: %s", len(errs), err.Error())
+	}
+	good := *NewTraderConfig(*tc.NewPair("btcusd"), TradeLimits{
+		MinOrderQuantity:   USDQuotePairMinQuantity,
+		MaxOrderQuantity:   decimal.New(1, 0),
+		MinOrderAmount:     USDQuotePairMinAmount,
+		MaxOrderAmount:     decimal.New(50, 0),
+		ProfitThresholdBps: decimal.New(12, 0),
+		FeeRateBps:         smartFee,
+	})
+	if err := ValidateTraderConfigs([]TraderConfig{good}); err != nil {
+		t.Errorf("unexpected error validating a good config: %s", err.Error())
+	}
+}
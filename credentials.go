@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// CredentialProvider is where the app gets its SFOX API keys from
+type CredentialProvider interface {
+	APIKeys() ([]APIKey, error)
+}
+
+const (
+	CredentialSourceEnv  = "env"
+	CredentialSourceFile = "file"
+	CredentialSourceAWS  = "aws"
+)
+
+type CredentialsConfig struct {
+	Source   string `json:"source"`   // one of env, file or aws
+	EnvVar   string `json:"envVar"`   // env: the variable holding the keys
+	File     string `json:"file"`     // file: the path of a file holding the keys
+	SecretID string `json:"secretId"` // aws: the Secrets Manager secret holding the keys
+	Region   string `json:"region"`   // aws: the region the secret lives in
+	// how often to re-read the keys so rotated keys are picked up, 0 only re-reads on SIGUSR1
+	RefreshInterval Duration `json:"refreshInterval"`
+}
+
+// DefaultCredentialsConfig matches how keys were always loaded, from the sfox-keys secret in us-east-2
+func DefaultCredentialsConfig() CredentialsConfig {
+	return CredentialsConfig{
+		Source:   CredentialSourceAWS,
+		EnvVar:   "SFOX_API_KEYS",
+		SecretID: "sfox-keys",
+		Region:   "us-east-2",
+	}
+}
+
+func (c CredentialsConfig) validate() (errs []error) {
+	switch c.Source {
+	case CredentialSourceEnv:
+		if c.EnvVar == "" {
+			errs = append(errs, fmt.Errorf("credentials: envVar is required for the env source"))
+		}
+	case CredentialSourceFile:
+		if c.File == "" {
+			errs = append(errs, fmt.Errorf("credentials: file is required for the file source"))
+		}
+	case CredentialSourceAWS:
+		if c.SecretID == "" {
+			errs = append(errs, fmt.Errorf("credentials: secretId is required for the aws source"))
+		}
+		if c.Region == "" {
+			errs = append(errs, fmt.Errorf("credentials: region is required for the aws source"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("credentials: unknown source %q, expected one of env, file or aws", c.Source))
+	}
+	if c.RefreshInterval.Duration < 0 {
+		errs = append(errs, fmt.Errorf("credentials: refreshInterval must not be negative"))
+	}
+	return errs
+}
+
+func NewCredentialProvider(c CredentialsConfig) (CredentialProvider, error) {
+	switch c.Source {
+	case CredentialSourceEnv:
+		return &envCredentialProvider{variable: c.EnvVar}, nil
+	case CredentialSourceFile:
+		return &fileCredentialProvider{path: c.File}, nil
+	case CredentialSourceAWS:
+		return &awsSecretsCredentialProvider{secretID: c.SecretID, region: c.Region}, nil
+	}
+	return nil, fmt.Errorf("unknown credential source %q", c.Source)
+}
+
+type envCredentialProvider struct {
+	variable string
+}
+
+func (p *envCredentialProvider) APIKeys() ([]APIKey, error) {
+	return parseAPIKeys(os.Getenv(p.variable), "$"+p.variable)
+}
+
+type fileCredentialProvider struct {
+	path string
+}
+
+func (p *fileCredentialProvider) APIKeys() ([]APIKey, error) {
+	data, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return nil, err
+	}
+	return parseAPIKeys(string(data), p.path)
+}
+
+// parseAPIKeys splits a comma or newline separated list of keys, optionally labelled with a role
+// (see keyRole), the format used by every provider. Every role must end up with at least one key.
+func parseAPIKeys(keysString string, source string) ([]APIKey, error) {
+	var keys []APIKey
+	for _, labelled := range strings.FieldsFunc(keysString, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if labelled = strings.TrimSpace(labelled); labelled == "" {
+			continue
+		}
+		key, err := parseAPIKey(labelled)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", source, err.Error())
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no API keys found in %s", source)
+	}
+	if err := validateKeyRoles(keys); err != nil {
+		return nil, fmt.Errorf("%s: %s", source, err.Error())
+	}
+	return keys, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseAPIKeysSplitsRoles(t *testing.T) {
+	keys, err := parseAPIKeys("read:aaa, trade:bbb\nccc", "test")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	read := keysForRole(keys, KeyRoleRead)
+	trade := keysForRole(keys, KeyRoleTrade)
+	if len(read) != 2 || read[0] != "aaa" || read[1] != "ccc" {
+		t.Errorf("unexpected read keys %v", read)
+	}
+	if len(trade) != 2 || trade[0] != "bbb" || trade[1] != "ccc" {
+		t.Errorf("unexpected trade keys %v", trade)
+	}
+}
+
+func TestParseAPIKeysRequiresEveryRole(t *testing.T) {
+	if _, err := parseAPIKeys("read:aaa,read:bbb", "test"); err == nil {
+		t.Errorf("expected an error when no key can trade")
+	}
+	if _, err := parseAPIKeys("admin:aaa,bbb", "test"); err == nil {
+		t.Errorf("expected an error for an unknown role")
+	}
+}
+
+func TestAPIKeyPrintsRedacted(t *testing.T) {
+	keys, _ := parseAPIKeys("read:secretaaa1111,trade:secretbbb2222", "test")
+	printed := fmt.Sprintf("%v %+v", keys, keys[0])
+	if strings.Contains(printed, "secret") {
+		t.Errorf("expected the keys to be redacted, got %s", printed)
+	}
+	if keys[0].Labelled() != "read:secretaaa1111" {
+		t.Errorf("unexpected labelled key %s", keys[0].Labelled())
+	}
+}
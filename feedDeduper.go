@@ -0,0 +1,138 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	ws "github.com/ldcicconi/ws-contractor"
+	"github.com/valyala/fastjson"
+)
+
+// slotMessage is a message from one of the redundant connections, slot is the connection's index and
+// connection the id feedHealth gave the group it was opened with
+type slotMessage struct {
+	slot       int
+	connection int
+	msg        ws.MessageEnvelope
+}
+
+// ConnectionStats is how one redundant connection did since the last report
+type ConnectionStats struct {
+	Messages    uint64
+	Wins        uint64        // messages it delivered before any other connection
+	MeanLatency time.Duration // from SFOX's timestamp to receipt
+	LastMessage time.Time
+}
+
+// WinRate is the share of its messages that got to us first on this connection
+func (s ConnectionStats) WinRate() float64 {
+	if s.Messages == 0 {
+		return 0
+	}
+	return float64(s.Wins) / float64(s.Messages)
+}
+
+// feedDeduper merges redundant connections with the same subscriptions. For each recipient, the first
+// copy of a sequence number is forwarded and later copies are dropped. Messages without a sequence,
+// like subscribe acks, are always forwarded. Sequences start over with each subscription, so the
+// connections are only comparable while they're all from the same group: every slot is reconnected
+// together, and messages still arriving from an older group are dropped.
+type feedDeduper struct {
+	parser      fastjson.Parser
+	connection  int              // the group being deduped, messages from others are dropped
+	latest      map[string]int64 // the highest sequence forwarded, by recipient
+	stats       []ConnectionStats
+	latency     []time.Duration // total latency this report, for MeanLatency
+	connectedAt []time.Time
+	lock        sync.Mutex
+}
+
+func NewFeedDeduper(connections int) *feedDeduper {
+	return &feedDeduper{
+		latest:      make(map[string]int64),
+		stats:       make([]ConnectionStats, connections),
+		latency:     make([]time.Duration, connections),
+		connectedAt: make([]time.Time, connections),
+	}
+}
+
+// reset forgets the sequences seen so far and follows the group connection, for when every connection
+// is replaced and the feeds start over
+func (d *feedDeduper) reset(connection int) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.connection = connection
+	d.latest = make(map[string]int64)
+}
+
+// connected records that slot has a new connection
+func (d *feedDeduper) connected(slot int, at time.Time) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.connectedAt[slot] = at
+	d.stats[slot].LastMessage = time.Time{}
+}
+
+// first returns true if m is the first copy of its message
+func (d *feedDeduper) first(m slotMessage) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if m.connection != d.connection {
+		// from a group that's been replaced, its sequences would hold back the new one's
+		return false
+	}
+	stats := &d.stats[m.slot]
+	stats.Messages++
+	stats.LastMessage = m.msg.ReceiptTimestamp
+	v, err := d.parser.ParseBytes(m.msg.Payload)
+	if err != nil {
+		// let the feedProcessor log it
+		return true
+	}
+	if ts := v.GetInt64("timestamp"); ts > 0 {
+		d.latency[m.slot] += m.msg.ReceiptTimestamp.Sub(time.Unix(0, ts))
+	}
+	recipient := string(v.GetStringBytes("recipient"))
+	sequence := v.GetInt64("sequence")
+	if recipient == "" {
+		return true
+	}
+	if latest, ok := d.latest[recipient]; ok && sequence <= latest {
+		return false
+	}
+	d.latest[recipient] = sequence
+	stats.Wins++
+	return true
+}
+
+// idleSlots returns the connections that have gone timeout without a message, or without their first one
+func (d *feedDeduper) idleSlots(now time.Time, timeout time.Duration) (slots []int) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for slot, stats := range d.stats {
+		since := stats.LastMessage
+		if since.IsZero() {
+			since = d.connectedAt[slot]
+		}
+		if now.Sub(since) > timeout {
+			slots = append(slots, slot)
+		}
+	}
+	return slots
+}
+
+// Stats returns each connection's stats since the last call
+func (d *feedDeduper) Stats() []ConnectionStats {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	stats := make([]ConnectionStats, len(d.stats))
+	for slot, s := range d.stats {
+		if s.Messages > 0 {
+			s.MeanLatency = d.latency[slot] / time.Duration(s.Messages)
+		}
+		stats[slot] = s
+		d.stats[slot] = ConnectionStats{LastMessage: s.LastMessage}
+		d.latency[slot] = 0
+	}
+	return stats
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	ws "github.com/ldcicconi/ws-contractor"
+)
+
+// capturedMessage is the captured btcusd book with its recipient, sequence and timestamp replaced
+func capturedMessage(tb testing.TB, recipient string, sequence int64, timestamp time.Time) []byte {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(loadCapturedMessages(tb)[0], &fields); err != nil {
+		tb.Fatal(err)
+	}
+	fields["recipient"] = json.RawMessage(strconv.Quote(recipient))
+	fields["sequence"] = json.RawMessage(strconv.FormatInt(sequence, 10))
+	fields["timestamp"] = json.RawMessage(strconv.FormatInt(timestamp.UnixNano(), 10))
+	payload, _ := json.Marshal(fields)
+	return payload
+}
+
+func TestFeedDeduperForwardsTheFirstCopy(t *testing.T) {
+	d := NewFeedDeduper(2)
+	d.reset(1)
+	start := time.Now()
+	message := func(slot int, recipient string, sequence int64, delay time.Duration) slotMessage {
+		payload := capturedMessage(t, recipient, sequence, start)
+		return slotMessage{slot: slot, connection: 1, msg: ws.MessageEnvelope{Payload: payload, ReceiptTimestamp: start.Add(delay)}}
+	}
+	steps := []struct {
+		m     slotMessage
+		first bool
+	}{
+		{message(0, "orderbook.sfox.btcusd", 2, 10*time.Millisecond), true},
+		{message(1, "orderbook.sfox.btcusd", 2, 30*time.Millisecond), false},
+		{message(1, "orderbook.sfox.btcusd", 3, 30*time.Millisecond), true},
+		{message(0, "orderbook.sfox.btcusd", 3, 10*time.Millisecond), false},
+		{message(0, "orderbook.sfox.ethusd", 3, 10*time.Millisecond), true},
+		{slotMessage{slot: 1, connection: 1, msg: ws.MessageEnvelope{Payload: []byte(`{"type":"success"}`), ReceiptTimestamp: start}}, true},
+	}
+	for i, step := range steps {
+		if first := d.first(step.m); first != step.first {
+			t.Errorf("step %d: expected first to be %v", i, step.first)
+		}
+	}
+	stats := d.Stats()
+	if stats[0].Messages != 3 || stats[0].Wins != 2 || stats[0].MeanLatency != 10*time.Millisecond {
+		t.Errorf("unexpected stats for connection 0: %+v", stats[0])
+	}
+	if stats[1].Messages != 3 || stats[1].Wins != 1 {
+		t.Errorf("unexpected stats for connection 1: %+v", stats[1])
+	}
+	if d.Stats()[0].Messages != 0 {
+		t.Error("expected the stats to start over after a report")
+	}
+}
+
+func TestFeedDeduperReplacingASlotReconnectsTheGroup(t *testing.T) {
+	d := NewFeedDeduper(2)
+	d.reset(1)
+	start := time.Now()
+	message := func(slot, connection int, sequence int64) slotMessage {
+		payload := capturedMessage(t, "orderbook.sfox.btcusd", sequence, start)
+		return slotMessage{slot: slot, connection: connection, msg: ws.MessageEnvelope{Payload: payload, ReceiptTimestamp: start}}
+	}
+	for sequence := int64(1); sequence <= 50; sequence++ {
+		d.first(message(0, 1, sequence))
+	}
+
+	// slot 1 went quiet, so both slots get new subscriptions, whose sequences start over
+	d.reset(2)
+	if d.first(message(0, 1, 51)) {
+		t.Error("expected a message from the replaced group to be dropped")
+	}
+	for sequence := int64(1); sequence <= 3; sequence++ {
+		if !d.first(message(1, 2, sequence)) {
+			t.Errorf("expected sequence %d from the new group to be forwarded", sequence)
+		}
+		if d.first(message(0, 2, sequence)) {
+			t.Errorf("expected the second copy of sequence %d to be dropped", sequence)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type feedStatus int
+
+const (
+	FeedConnecting feedStatus = iota // connected or connecting, no messages yet
+	FeedLive
+	FeedStale // no messages for a while, but not long enough to give up on the connection
+	FeedDown  // given up on, waiting to reconnect
+)
+
+func (s feedStatus) String() string {
+	switch s {
+	case FeedConnecting:
+		return "connecting"
+	case FeedLive:
+		return "live"
+	case FeedStale:
+		return "stale"
+	case FeedDown:
+		return "down"
+	}
+	return "unknown"
+}
+
+type FeedHealthConfig struct {
+	StaleAfter     Duration `json:"staleAfter"`     // the feed is stale after this long without a message
+	IdleTimeout    Duration `json:"idleTimeout"`    // and reconnected after this long
+	BackoffInitial Duration `json:"backoffInitial"` // wait before the first reconnect, doubling each time it fails
+	BackoffMax     Duration `json:"backoffMax"`
+}
+
+func DefaultFeedHealthConfig() FeedHealthConfig {
+	return FeedHealthConfig{
+		StaleAfter:     Duration{5 * time.Second},
+		IdleTimeout:    Duration{15 * time.Second},
+		BackoffInitial: Duration{time.Second},
+		BackoffMax:     Duration{time.Minute},
+	}
+}
+
+func (c FeedHealthConfig) validate() (errs []error) {
+	if c.StaleAfter.Duration <= 0 || c.IdleTimeout.Duration <= 0 || c.BackoffInitial.Duration <= 0 || c.BackoffMax.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("marketData.health: staleAfter, idleTimeout, backoffInitial and backoffMax must be greater than 0"))
+	}
+	if c.IdleTimeout.Duration < c.StaleAfter.Duration {
+		errs = append(errs, fmt.Errorf("marketData.health: idleTimeout must not be less than staleAfter"))
+	}
+	if c.BackoffMax.Duration < c.BackoffInitial.Duration {
+		errs = append(errs, fmt.Errorf("marketData.health: backoffMax must not be less than backoffInitial"))
+	}
+	return errs
+}
+
+// feedHealth follows the current websocket connection. Each connection gets an id, and only messages
+// from the current one count, so a connection we've given up on can't make the feed look live.
+type feedHealth struct {
+	config      FeedHealthConfig
+	connection  int
+	connectedAt time.Time
+	lastMessage time.Time // zero until the current connection gets a message
+	down        bool
+	lock        sync.Mutex
+}
+
+func NewFeedHealth(config FeedHealthConfig) *feedHealth {
+	return &feedHealth{
+		config: config,
+		down:   true,
+	}
+}
+
+// connecting starts following a new connection and returns its id
+func (h *feedHealth) connecting() int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.connection++
+	h.connectedAt, h.lastMessage, h.down = time.Now(), time.Time{}, false
+	return h.connection
+}
+
+func (h *feedHealth) giveUp() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.down = true
+}
+
+// received records a message from connection and returns false if that isn't the current connection
+func (h *feedHealth) received(connection int, at time.Time) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if connection != h.connection || h.down {
+		return false
+	}
+	h.lastMessage = at
+	return true
+}
+
+func (h *feedHealth) Status() feedStatus {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.status(time.Now())
+}
+
+// status must be called with the lock held
+func (h *feedHealth) status(now time.Time) feedStatus {
+	switch {
+	case h.down:
+		return FeedDown
+	case h.lastMessage.IsZero():
+		return FeedConnecting
+	case now.Sub(h.lastMessage) > h.config.StaleAfter.Duration:
+		return FeedStale
+	}
+	return FeedLive
+}
+
+// idle is true once the current connection has gone idleTimeout without a message, or without its first one
+func (h *feedHealth) idle(now time.Time) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.down {
+		return false
+	}
+	since := h.lastMessage
+	if since.IsZero() {
+		since = h.connectedAt
+	}
+	return now.Sub(since) > h.config.IdleTimeout.Duration
+}
+
+// live is true once the current connection has had a message, used to reset the reconnect backoff
+func (h *feedHealth) live() bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return !h.down && !h.lastMessage.IsZero()
+}
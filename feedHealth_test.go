@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFeedHealthStatus(t *testing.T) {
+	h := NewFeedHealth(DefaultFeedHealthConfig())
+	if h.Status() != FeedDown {
+		t.Errorf("expected the feed to be down before connecting, got %s", h.Status())
+	}
+	first := h.connecting()
+	now := time.Now()
+	if h.status(now) != FeedConnecting || h.idle(now) {
+		t.Errorf("expected a new connection to be connecting, got %s", h.status(now))
+	}
+	if h.idle(now.Add(16*time.Second)) != true {
+		t.Errorf("expected a connection that never gets a message to go idle")
+	}
+	h.received(first, now)
+	if h.status(now.Add(time.Second)) != FeedLive || !h.live() {
+		t.Errorf("expected the feed to be live after a message")
+	}
+	if h.status(now.Add(6*time.Second)) != FeedStale || h.idle(now.Add(6*time.Second)) {
+		t.Errorf("expected the feed to be stale but not idle after 6s")
+	}
+	if !h.idle(now.Add(16 * time.Second)) {
+		t.Errorf("expected the feed to be idle after 16s")
+	}
+	h.giveUp()
+	second := h.connecting()
+	if h.received(first, now.Add(20*time.Second)) {
+		t.Errorf("a message from a connection that was given up on shouldn't count")
+	}
+	if h.status(now.Add(20*time.Second)) != FeedConnecting {
+		t.Errorf("expected the new connection to still be connecting")
+	}
+	if !h.received(second, now.Add(20*time.Second)) || h.status(now.Add(20*time.Second)) != FeedLive {
+		t.Errorf("expected a message on the new connection to make the feed live")
+	}
+}
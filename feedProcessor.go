@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	tc "github.com/ldcicconi/trading-common"
+	ws "github.com/ldcicconi/ws-contractor"
+)
+
+// feedProcessor turns raw SFOX feed messages into books. Every source that starts from raw
+// messages goes through one, so live and replayed data are treated the same.
+type feedProcessor struct {
+	recorder  *captureRecorder // nil unless recording
+	sequences *sequenceTracker
+	staleness *stalenessChecker
+	parser    *sfoxParser
+	Logger    *log.Logger
+}
+
+func NewFeedProcessor(recorder *captureRecorder, staleness StalenessConfig, logger *log.Logger) *feedProcessor {
+	return &feedProcessor{
+		recorder:  recorder,
+		sequences: NewSequenceTracker(),
+		staleness: NewStalenessChecker(staleness),
+		parser:    NewSFOXParser(),
+		Logger:    logger,
+	}
+}
+
+// Run processes messages until rawDataChan is closed
+func (p *feedProcessor) Run(rawDataChan chan ws.MessageEnvelope, orderbookChan chan sfoxBook) {
+	stop := make(chan struct{})
+	defer close(stop)
+	go p.reportStats(stop)
+	for msg := range rawDataChan {
+		if o, ok := p.process(msg); ok {
+			orderbookChan <- o
+		}
+	}
+}
+
+// process returns false for messages that aren't books, or are books that can't be trusted
+func (p *feedProcessor) process(msg ws.MessageEnvelope) (sfoxBook, bool) {
+	p.recorder.Record(msg)
+	parsed, err := p.parser.Parse(msg.Payload)
+	if err != nil {
+		p.LogInfo("ERROR: " + err.Error())
+		return sfoxBook{}, false
+	}
+	if parsed.Recipient != "" {
+		switch result := p.sequences.Check(parsed.Recipient, parsed.Sequence); result {
+		case sequenceGap:
+			p.LogInfo(fmt.Sprintf("sequence gap on %s at %d, dropping that book", parsed.Recipient, parsed.Sequence))
+			return sfoxBook{}, false
+		case sequenceDuplicate, sequenceOutOfOrder:
+			return sfoxBook{}, false
+		}
+	}
+	book, err := parsed.Book(msg.ReceiptTimestamp)
+	if err == tc.ErrFirstMessage {
+		p.LogInfo("Received messsage w/ sequence=1")
+		return sfoxBook{}, false
+	} else if err == errNotABook {
+		p.LogInfo("ignoring message: " + string(msg.Payload))
+		return sfoxBook{}, false
+	} else if err != nil {
+		p.LogInfo("ERROR: " + err.Error())
+		return sfoxBook{}, false
+	}
+	return p.staleness.Check(book), true
+}
+
+func msTime(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// reportStats logs the feeds that have had sequence problems and the venues that have been stale, every minute
+func (p *feedProcessor) reportStats(stop chan struct{}) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+		for recipient, stats := range p.sequences.Stats() {
+			if stats != (SequenceStats{}) {
+				p.LogInfo(fmt.Sprintf("%s since startup: %s", recipient, stats))
+			}
+		}
+		for venue, n := range p.staleness.Counts() {
+			p.LogInfo(fmt.Sprintf("%s has been stale in %d books since startup", venue, n))
+		}
+		if n := p.staleness.UnknownVenues(); n > 0 {
+			p.LogInfo(fmt.Sprintf("%d book(s) since startup had stale venues but no venue for each offer, all their offers were dropped", n))
+		}
+	}
+}
+
+func (p *feedProcessor) LogInfo(text string) {
+	p.Logger.Println("[feed] [info] " + text)
+}
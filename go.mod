@@ -4,6 +4,7 @@ go 1.13
 
 require (
 	github.com/aws/aws-sdk-go v1.28.1
+	github.com/gorilla/websocket v1.4.1
 	github.com/ldcicconi/sfox-api-lib v0.0.0-20200114162612-5413daa3e148
 	github.com/ldcicconi/trading-common v0.0.0-20191215231423-972966632a40
 	github.com/ldcicconi/ws-contractor v0.0.0-20191110170019-88afc346ecef
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// keyRotator re-reads the API keys every interval, and on SIGUSR1, and hands any change to apply
+type keyRotator struct {
+	provider CredentialProvider
+	interval time.Duration
+	apply    func([]APIKey) (added, retired int)
+	Logger   *log.Logger
+	current  string
+}
+
+func NewKeyRotator(provider CredentialProvider, interval time.Duration, apply func([]APIKey) (added, retired int), initialKeys []APIKey, logger *log.Logger) *keyRotator {
+	return &keyRotator{
+		provider: provider,
+		interval: interval,
+		apply:    apply,
+		Logger:   logger,
+		current:  keySetID(initialKeys),
+	}
+}
+
+func (r *keyRotator) Start() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	var tick <-chan time.Time
+	if r.interval > 0 {
+		ticker := time.NewTicker(r.interval)
+		tick = ticker.C
+	}
+	go func() {
+		for {
+			select {
+			case <-signals:
+				r.LogInfo("SIGUSR1 received, refreshing API keys")
+			case <-tick:
+			}
+			r.refresh()
+		}
+	}()
+}
+
+func (r *keyRotator) LogInfo(text string) {
+	r.Logger.Println("[keyRotator] [info] " + text)
+}
+
+func (r *keyRotator) refresh() {
+	keys, err := r.provider.APIKeys()
+	if err != nil {
+		r.Logger.Println("[keyRotator] [error] keeping the current keys, refresh failed: " + err.Error())
+		return
+	}
+	id := keySetID(keys)
+	if id == r.current {
+		return
+	}
+	r.current = id
+	added, retired := r.apply(keys)
+	// never log the keys themselves
+	r.LogInfo(fmt.Sprintf("API keys rotated: now using %d key(s), %d client(s) added, %d client(s) retired", len(keys), added, retired))
+}
+
+// keySetID is an order independent identity for a set of keys, used to tell if anything changed
+func keySetID(keys []APIKey) string {
+	sorted := make([]string, 0, len(keys))
+	for _, k := range keys {
+		sorted = append(sorted, k.Labelled())
+	}
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	tc "github.com/ldcicconi/trading-common"
+)
+
+// LatencyConfig sizes the rolling latency windows kept for each pair
+type LatencyConfig struct {
+	Window         int      `json:"window"`         // samples kept for each measure, older ones roll off
+	ReportInterval Duration `json:"reportInterval"` // how often the summaries are logged
+}
+
+func DefaultLatencyConfig() LatencyConfig {
+	return LatencyConfig{
+		Window:         1000,
+		ReportInterval: Duration{time.Minute},
+	}
+}
+
+func (c LatencyConfig) validate() (errs []error) {
+	if c.Window <= 0 {
+		errs = append(errs, fmt.Errorf("latency: window must be greater than 0"))
+	}
+	if c.ReportInterval.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("latency: reportInterval must be greater than 0"))
+	}
+	return errs
+}
+
+// LatencySummary is the distribution of the samples in a window
+type LatencySummary struct {
+	Count int
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+func (s LatencySummary) String() string {
+	return fmt.Sprintf("p50 %s p90 %s p99 %s max %s (n=%d)", s.P50, s.P90, s.P99, s.Max, s.Count)
+}
+
+// latencyWindow is a ring buffer of the last len(samples) durations
+type latencyWindow struct {
+	samples []time.Duration
+	next    int
+	full    bool
+}
+
+func newLatencyWindow(size int) *latencyWindow {
+	return &latencyWindow{samples: make([]time.Duration, size)}
+}
+
+func (w *latencyWindow) Add(d time.Duration) {
+	w.samples[w.next] = d
+	w.next++
+	if w.next == len(w.samples) {
+		w.next, w.full = 0, true
+	}
+}
+
+func (w *latencyWindow) Summary() LatencySummary {
+	n := w.next
+	if w.full {
+		n = len(w.samples)
+	}
+	if n == 0 {
+		return LatencySummary{}
+	}
+	sorted := make([]time.Duration, n)
+	copy(sorted, w.samples[:n])
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	percentile := func(p float64) time.Duration {
+		return sorted[int(p*float64(n-1))]
+	}
+	return LatencySummary{
+		Count: n,
+		P50:   percentile(0.5),
+		P90:   percentile(0.9),
+		P99:   percentile(0.99),
+		Max:   sorted[n-1],
+	}
+}
+
+// PairLatency summarizes a pair's latency windows
+type PairLatency struct {
+	Network  LatencySummary            // SFOX's timestamp to our receipt
+	Internal LatencySummary            // our receipt to the trader picking the book up
+	VenueAge map[string]LatencySummary // how old each venue's quotes were when SFOX published the book
+}
+
+type pairLatency struct {
+	network  *latencyWindow
+	internal *latencyWindow
+	venueAge map[string]*latencyWindow
+}
+
+// latencyStats keeps rolling latency windows for each pair, fed with the books the traders pick up
+type latencyStats struct {
+	config LatencyConfig
+	pairs  map[tc.Pair]*pairLatency
+	lock   sync.Mutex
+}
+
+func NewLatencyStats(config LatencyConfig) *latencyStats {
+	return &latencyStats{
+		config: config,
+		pairs:  make(map[tc.Pair]*pairLatency),
+	}
+}
+
+// Observe records b's latencies, now being when the trader picked it up. A nil latencyStats ignores it.
+func (l *latencyStats) Observe(b sfoxBook, now time.Time) {
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	p, ok := l.pairs[b.Pair]
+	if !ok {
+		p = &pairLatency{
+			network:  newLatencyWindow(l.config.Window),
+			internal: newLatencyWindow(l.config.Window),
+			venueAge: make(map[string]*latencyWindow),
+		}
+		l.pairs[b.Pair] = p
+	}
+	p.network.Add(b.ReceiptTimestamp.Sub(b.SFOXTimestamp))
+	p.internal.Add(now.Sub(b.ReceiptTimestamp))
+	for venue := range b.VenueTimestamps {
+		age, _ := b.VenueAge(venue)
+		w, ok := p.venueAge[venue]
+		if !ok {
+			w = newLatencyWindow(l.config.Window)
+			p.venueAge[venue] = w
+		}
+		w.Add(age)
+	}
+}
+
+// Pair returns pair's latency summaries, false if it hasn't had a book yet
+func (l *latencyStats) Pair(pair tc.Pair) (PairLatency, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	p, ok := l.pairs[pair]
+	if !ok {
+		return PairLatency{}, false
+	}
+	summary := PairLatency{
+		Network:  p.network.Summary(),
+		Internal: p.internal.Summary(),
+		VenueAge: make(map[string]LatencySummary),
+	}
+	for venue, w := range p.venueAge {
+		summary.VenueAge[venue] = w.Summary()
+	}
+	return summary, true
+}
+
+// Pairs returns the pairs that have had a book
+func (l *latencyStats) Pairs() (pairs []tc.Pair) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for pair := range l.pairs {
+		pairs = append(pairs, pair)
+	}
+	return pairs
+}
+
+// report is a log line for each pair
+func (l *latencyStats) report() (lines []string) {
+	pairs := l.Pairs()
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].String() < pairs[j].String() })
+	for _, pair := range pairs {
+		summary, _ := l.Pair(pair)
+		var venues []string
+		for venue := range summary.VenueAge {
+			venues = append(venues, venue)
+		}
+		sort.Strings(venues)
+		var ages []string
+		for _, venue := range venues {
+			ages = append(ages, fmt.Sprintf("%s p50 %s p99 %s", venue, summary.VenueAge[venue].P50, summary.VenueAge[venue].P99))
+		}
+		lines = append(lines, fmt.Sprintf("%s latency network: %s, internal: %s, venue quote age: %s",
+			pair, summary.Network, summary.Internal, strings.Join(ages, ", ")))
+	}
+	return lines
+}
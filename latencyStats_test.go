@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	tc "github.com/ldcicconi/trading-common"
+)
+
+func TestLatencyWindowRollsOver(t *testing.T) {
+	w := newLatencyWindow(100)
+	for i := 1; i <= 150; i++ {
+		w.Add(time.Duration(i) * time.Millisecond)
+	}
+	s := w.Summary()
+	if s.Count != 100 || s.P50 != 100*time.Millisecond || s.P99 != 149*time.Millisecond || s.Max != 150*time.Millisecond {
+		t.Errorf("expected the last 100 samples to be summarized, got %s", s)
+	}
+}
+
+func TestLatencyStatsObserve(t *testing.T) {
+	l := NewLatencyStats(DefaultLatencyConfig())
+	pair := *tc.NewPair("btcusd")
+	published := time.Now()
+	b := sfoxBook{
+		SFOXOrderbook: tc.SFOXOrderbook{
+			Pair:             pair,
+			SFOXTimestamp:    published,
+			ReceiptTimestamp: published.Add(20 * time.Millisecond),
+		},
+		VenueTimestamps: map[string]time.Time{"gemini": published.Add(-time.Second)},
+		LastPublished:   published,
+	}
+	l.Observe(b, published.Add(25*time.Millisecond))
+	summary, ok := l.Pair(pair)
+	if !ok {
+		t.Fatal("expected a summary for btcusd")
+	}
+	if summary.Network.P50 != 20*time.Millisecond || summary.Internal.P50 != 5*time.Millisecond {
+		t.Errorf("unexpected network or internal latency: %+v", summary)
+	}
+	if summary.VenueAge["gemini"].P50 != time.Second {
+		t.Errorf("expected gemini's quotes to be 1s old, got %s", summary.VenueAge["gemini"])
+	}
+	if _, ok := l.Pair(*tc.NewPair("ethusd")); ok {
+		t.Error("expected no summary for a pair without books")
+	}
+	if lines := l.report(); len(lines) != 1 {
+		t.Errorf("expected a report line for btcusd, got %v", lines)
+	}
+}
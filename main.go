@@ -1,12 +1,11 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"net/url"
 	"os"
-	"strings"
 
-	tc "github.com/ldcicconi/trading-common"
 	"github.com/shopspring/decimal"
 )
 
@@ -21,78 +20,59 @@ var (
 	}
 
 	/*
-		Trader Configs
+		SFOX-defined limits
 	*/
-	// User-defined configs
-	profitThresholdBps    = decimal.New(12, 0)
-	USDQuotePairMaxAmount = decimal.New(50, 0)
-
-	// SFOX-defined limits
 	smartFee                = decimal.New(175, -1) // 17.5bps
 	USDQuotePairMinQuantity = decimal.New(1, -3)   // 0.001
 	USDQuotePairMinAmount   = decimal.New(5, 0)    // $5
 	BTCQuotePairMinQuantity = decimal.New(1, -3)
 	BTCQuotePairMinAmount   = decimal.New(1, -3)
 
-	defaultConfigs = []TraderConfig{
+	/*
+		Default max order sizes, overridable per quote currency or per pair in the config file
+	*/
+	USDQuotePairMaxAmount = decimal.New(50, 0) // $50
+	BTCQuotePairMaxAmount = decimal.New(5, -3) // 0.005btc
 
-		*NewTraderConfig(*tc.NewPair("btcusd"), TradeLimits{
-			MinOrderQuantity:   USDQuotePairMinQuantity,
-			MaxOrderQuantity:   decimal.New(1, 0),
-			MinOrderAmount:     USDQuotePairMinAmount,
-			MaxOrderAmount:     USDQuotePairMaxAmount,
-			ProfitThresholdBps: profitThresholdBps,
-			FeeRateBps:         smartFee,
-		}),
-		*NewTraderConfig(*tc.NewPair("etcusd"), TradeLimits{
-			MinOrderQuantity:   USDQuotePairMinQuantity,
-			MaxOrderQuantity:   decimal.New(100, 0),
-			MinOrderAmount:     USDQuotePairMinAmount,
-			MaxOrderAmount:     USDQuotePairMaxAmount,
-			ProfitThresholdBps: profitThresholdBps,
-			FeeRateBps:         smartFee,
-		}),
-		*NewTraderConfig(*tc.NewPair("ethusd"), TradeLimits{
-			MinOrderQuantity:   USDQuotePairMinQuantity,
-			MaxOrderQuantity:   decimal.New(100, 0),
-			MinOrderAmount:     USDQuotePairMinAmount,
-			MaxOrderAmount:     USDQuotePairMaxAmount,
-			ProfitThresholdBps: profitThresholdBps,
-			FeeRateBps:         smartFee,
-		}),
-		*NewTraderConfig(*tc.NewPair("ltcusd"), TradeLimits{
-			MinOrderQuantity:   USDQuotePairMinQuantity,
-			MaxOrderQuantity:   decimal.New(100, 0),
-			MinOrderAmount:     USDQuotePairMinAmount,
-			MaxOrderAmount:     USDQuotePairMaxAmount,
-			ProfitThresholdBps: profitThresholdBps,
-			FeeRateBps:         smartFee,
-		}),
-		*NewTraderConfig(*tc.NewPair("bchusd"), TradeLimits{
-			MinOrderQuantity:   USDQuotePairMinQuantity,
-			MaxOrderQuantity:   decimal.New(100, 0),
-			MinOrderAmount:     USDQuotePairMinAmount,
-			MaxOrderAmount:     USDQuotePairMaxAmount,
-			ProfitThresholdBps: profitThresholdBps,
-			FeeRateBps:         smartFee,
-		}),
-	}
+	configPath = flag.String("config", "config.json", "path to the trader config file")
 )
 
-func getAPIKeysFromEnv() ([]string, error) {
-	keysString := os.Getenv("SFOX_API_KEYS")
-	return strings.Split(keysString, ","), nil
-}
-
 func main() {
-	apiKeys, err := getAPIKeysFromAWSSecrets()
+	flag.Parse()
+	config, err := LoadConfig(*configPath)
 	if err != nil {
-		fmt.Println("[startup] failure to get API Keys:", err)
+		fmt.Println("[startup] failure to load config:", err)
+		os.Exit(1)
+		return
+	}
+	// with simulated market data nothing goes to SFOX, so there's no need for keys
+	var credentials CredentialProvider
+	var apiKeys []APIKey
+	if !config.MarketData.Simulated() {
+		credentials, err = NewCredentialProvider(config.Credentials)
+		if err != nil {
+			fmt.Println("[startup] failure to get API Keys:", err)
+			os.Exit(1)
+			return
+		}
+		apiKeys, err = credentials.APIKeys()
+		if err != nil {
+			fmt.Println("[startup] failure to get API Keys:", err)
+			os.Exit(1)
+			return
+		}
+	}
+	myApp, err := NewSFOXArbApp(config, apiKeys)
+	if err != nil {
+		fmt.Println("[startup] failure to set up market data:", err)
 		os.Exit(1)
 		return
 	}
-	myApp := NewSFOXArbApp(defaultConfigs, apiKeys)
 	myApp.Start()
+	myApp.WatchConfig(*configPath)
+	if credentials != nil {
+		myApp.WatchCredentials(credentials, config.Credentials.RefreshInterval.Duration, apiKeys)
+	}
 	forever := make(chan bool)
 	<-forever
 }
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	tc "github.com/ldcicconi/trading-common"
+	ws "github.com/ldcicconi/ws-contractor"
+)
+
+// replayMarketData is a MarketDataSource that plays back captured feed messages. Captures are JSONL,
+// optionally gzipped, with either raw feed messages exactly as they came off the websocket or lines
+// written by the captureRecorder, which carry the time each message was received.
+type replayMarketData struct {
+	config    ReplayConfig
+	staleness StalenessConfig
+	finished  int32 // set atomically once the replay is over, the feed is down from then on
+	Logger    *log.Logger
+}
+
+const (
+	ReplayTimingOriginal    = "original"    // messages are spaced out as they were captured
+	ReplayTimingAccelerated = "accelerated" // as captured, sped up by speed
+	ReplayTimingFast        = "fast"        // as fast as the traders take them
+)
+
+type ReplayConfig struct {
+	File   string  `json:"file"`   // a path or glob, matching files are played in name order
+	Loop   bool    `json:"loop"`   // start over from the top when the files run out
+	Timing string  `json:"timing"` // one of original, accelerated or fast
+	Speed  float64 `json:"speed"`  // how many times faster than captured accelerated playback runs
+}
+
+func DefaultReplayConfig() ReplayConfig {
+	return ReplayConfig{
+		Timing: ReplayTimingFast,
+		Speed:  10,
+	}
+}
+
+func (c ReplayConfig) validate() (errs []error) {
+	if c.File == "" {
+		errs = append(errs, fmt.Errorf("marketData.replay: file is required"))
+	} else if _, err := filepath.Glob(c.File); err != nil {
+		errs = append(errs, fmt.Errorf("marketData.replay: bad file pattern %q", c.File))
+	}
+	switch c.Timing {
+	case ReplayTimingOriginal, ReplayTimingFast:
+	case ReplayTimingAccelerated:
+		if c.Speed <= 0 {
+			errs = append(errs, fmt.Errorf("marketData.replay: speed must be greater than 0"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("marketData.replay: unknown timing %q, expected one of original, accelerated or fast", c.Timing))
+	}
+	return errs
+}
+
+// max size of one captured message, full depth books run to tens of KB
+const replayMaxLineSize = 4 * 1024 * 1024
+
+// captureLine covers both capture formats: recorder lines set ReceiptTimestamp and Message,
+// raw feed messages only have the feed's own Timestamp
+type captureLine struct {
+	ReceiptTimestamp int64           `json:"receiptTimestamp"`
+	Message          json.RawMessage `json:"message"`
+	Timestamp        int64           `json:"timestamp"`
+}
+
+func NewReplayMarketData(config ReplayConfig, staleness StalenessConfig, logger *log.Logger) *replayMarketData {
+	return &replayMarketData{
+		config:    config,
+		staleness: staleness,
+		Logger:    logger,
+	}
+}
+
+func (r *replayMarketData) Start(orderbookChan chan sfoxBook) {
+	go func() {
+		for {
+			files, _ := filepath.Glob(r.config.File)
+			if len(files) == 0 {
+				r.LogInfo("no captures match " + r.config.File)
+				atomic.StoreInt32(&r.finished, 1)
+				return
+			}
+			if err := r.replayPass(files, orderbookChan); err != nil {
+				atomic.StoreInt32(&r.finished, 1)
+				return
+			}
+			if !r.config.Loop {
+				r.LogInfo("replay finished")
+				atomic.StoreInt32(&r.finished, 1)
+				return
+			}
+		}
+	}()
+}
+
+// replayPass plays files once, through a feedProcessor of its own. A looped capture's sequences go back to
+// wherever the file starts, which the last pass's sequence tracker would drop as already seen.
+// It returns once the processor has handled the last message.
+func (r *replayMarketData) replayPass(files []string, orderbookChan chan sfoxBook) error {
+	rawDataChan := make(chan ws.MessageEnvelope)
+	processed := make(chan struct{})
+	go func() {
+		NewFeedProcessor(nil, r.staleness, r.Logger).Run(rawDataChan, orderbookChan)
+		close(processed)
+	}()
+	defer func() {
+		close(rawDataChan)
+		<-processed
+	}()
+	clock := &replayClock{timing: r.config.Timing, speed: r.config.Speed}
+	for _, file := range files {
+		start := time.Now()
+		n, err := r.replayFile(file, clock, rawDataChan)
+		if err != nil {
+			r.LogInfo("replay of " + file + " stopped: " + err.Error())
+			return err
+		}
+		r.LogInfo(fmt.Sprintf("replayed %d messages from %s in %s", n, file, time.Since(start)))
+	}
+	return nil
+}
+
+// SetPairs does nothing, a capture replays every pair it has. The traderManager drops books for pairs without a trader.
+func (r *replayMarketData) SetPairs(pairs []tc.Pair) {
+	r.LogInfo(fmt.Sprintf("ignoring the change to %d pairs, the replay has the pairs it was captured with", len(pairs)))
+}
+
+func (r *replayMarketData) Status() feedStatus {
+	if atomic.LoadInt32(&r.finished) == 1 {
+		return FeedDown
+	}
+	return FeedLive
+}
+
+func (r *replayMarketData) replayFile(path string, clock *replayClock, rawDataChan chan ws.MessageEnvelope) (n int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var in io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return 0, err
+		}
+		defer gz.Close()
+		in = gz
+	}
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), replayMaxLineSize)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		msg, err := parseCaptureLine(line)
+		if err != nil {
+			r.LogInfo(fmt.Sprintf("skipping %s:%d: %s", path, lineNumber, err.Error()))
+			continue
+		}
+		clock.wait(msg.ReceiptTimestamp)
+		rawDataChan <- msg
+		n++
+	}
+	return n, scanner.Err()
+}
+
+// parseCaptureLine turns a line of either capture format back into the message as it was received.
+// Raw feed messages weren't stamped when they were received, so the feed's timestamp stands in for it.
+func parseCaptureLine(line []byte) (ws.MessageEnvelope, error) {
+	var parsed captureLine
+	if err := json.Unmarshal(line, &parsed); err != nil {
+		return ws.MessageEnvelope{}, err
+	}
+	// the scanner reuses its buffer, and the message outlives this line
+	if parsed.Message != nil {
+		return ws.MessageEnvelope{Payload: []byte(parsed.Message), ReceiptTimestamp: time.Unix(0, parsed.ReceiptTimestamp)}, nil
+	}
+	payload := make([]byte, len(line))
+	copy(payload, line)
+	return ws.MessageEnvelope{Payload: payload, ReceiptTimestamp: time.Unix(0, parsed.Timestamp)}, nil
+}
+
+// replayClock paces messages by their capture times, relative to the first message it sees
+type replayClock struct {
+	timing       string
+	speed        float64
+	firstCapture time.Time
+	firstReplay  time.Time
+}
+
+func (c *replayClock) wait(captured time.Time) {
+	if c.timing == ReplayTimingFast {
+		return
+	}
+	if c.firstReplay.IsZero() {
+		c.firstCapture, c.firstReplay = captured, time.Now()
+		return
+	}
+	elapsed := captured.Sub(c.firstCapture)
+	if c.timing == ReplayTimingAccelerated {
+		elapsed = time.Duration(float64(elapsed) / c.speed)
+	}
+	if d := time.Until(c.firstReplay.Add(elapsed)); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+func (r *replayMarketData) LogInfo(text string) {
+	r.Logger.Println("[marketdata-replay] [info] " + text)
+}
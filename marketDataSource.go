@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	tc "github.com/ldcicconi/trading-common"
+)
+
+// MarketDataSource produces SFOX books for the traders. The live websocket feed is one,
+// recorded captures and generated books are the others, so everything downstream runs the same
+// without a connection to SFOX.
+type MarketDataSource interface {
+	Start(orderbookChan chan sfoxBook)
+	// Status says whether the books coming out are current. Traders don't start arbs unless it's FeedLive.
+	Status() feedStatus
+	// SetPairs changes the pairs books are produced for, while running
+	SetPairs(pairs []tc.Pair)
+}
+
+const (
+	MarketDataSourceWebsocket = "websocket"
+	MarketDataSourceReplay    = "replay"
+	MarketDataSourceSynthetic = "synthetic"
+)
+
+// anything but the live feed is simulated, orders go to the simulatedVenue instead of SFOX
+func (c MarketDataConfig) Simulated() bool {
+	return c.Source != MarketDataSourceWebsocket
+}
+
+type MarketDataConfig struct {
+	Source    string           `json:"source"` // one of websocket, replay or synthetic
+	Replay    ReplayConfig     `json:"replay"`
+	Synthetic SyntheticConfig  `json:"synthetic"`
+	Record    RecorderConfig   `json:"record"` // captures the live feed, ignored for the other sources
+	Staleness StalenessConfig  `json:"staleness"`
+	Health    FeedHealthConfig `json:"health"` // only for the live feed
+	// Connections is how many websocket connections to hold with the same subscriptions, each
+	// message is taken from whichever delivers it first. Only for the live feed.
+	Connections int `json:"connections"`
+}
+
+func DefaultMarketDataConfig() MarketDataConfig {
+	return MarketDataConfig{
+		Source:      MarketDataSourceWebsocket,
+		Replay:      DefaultReplayConfig(),
+		Synthetic:   DefaultSyntheticConfig(),
+		Record:      DefaultRecorderConfig(),
+		Staleness:   DefaultStalenessConfig(),
+		Health:      DefaultFeedHealthConfig(),
+		Connections: 1,
+	}
+}
+
+func (c MarketDataConfig) validate() (errs []error) {
+	errs = append(c.Record.validate(), c.Staleness.validate()...)
+	errs = append(errs, c.Health.validate()...)
+	if c.Connections < 1 {
+		errs = append(errs, fmt.Errorf("marketData: connections must be at least 1"))
+	}
+	switch c.Source {
+	case MarketDataSourceWebsocket:
+	case MarketDataSourceReplay:
+		errs = append(errs, c.Replay.validate()...)
+	case MarketDataSourceSynthetic:
+		errs = append(errs, c.Synthetic.validate()...)
+	default:
+		errs = append(errs, fmt.Errorf("marketData: unknown source %q, expected one of websocket, replay or synthetic", c.Source))
+	}
+	return errs
+}
+
+func NewMarketDataSource(c MarketDataConfig, pairs []tc.Pair, logger *log.Logger) (MarketDataSource, error) {
+	switch c.Source {
+	case MarketDataSourceWebsocket:
+		md := NewSFOXMarketData(SFOXURL, pairs, logger)
+		md.staleness = c.Staleness
+		md.health = NewFeedHealth(c.Health)
+		if c.Connections > 1 {
+			md.connections = c.Connections
+			md.deduper = NewFeedDeduper(c.Connections)
+		}
+		if c.Record.Dir != "" {
+			md.recorder = NewCaptureRecorder(c.Record, logger)
+		}
+		return md, nil
+	case MarketDataSourceReplay:
+		return NewReplayMarketData(c.Replay, c.Staleness, logger), nil
+	case MarketDataSourceSynthetic:
+		return NewSyntheticMarketData(c.Synthetic, pairs, logger), nil
+	}
+	return nil, fmt.Errorf("unknown market data source %q", c.Source)
+}
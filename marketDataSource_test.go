@@ -0,0 +1,210 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	tc "github.com/ldcicconi/trading-common"
+)
+
+func TestSyntheticBooksAreSorted(t *testing.T) {
+	config := DefaultSyntheticConfig()
+	config.Seed = 1
+	config.ArbProbability = 0
+	pair := *tc.NewPair("btcusd")
+	s := NewSyntheticMarketData(config, []tc.Pair{pair}, testLogger)
+	for i := 0; i < 100; i++ {
+		book := s.nextBook(pair)
+		if book.Pair != pair || len(book.Bids) != config.Levels || len(book.Asks) != config.Levels {
+			t.Fatalf("malformed book: %+v", book)
+		}
+		if !book.Bids[0].Price.LessThan(book.Asks[0].Price) {
+			t.Fatalf("book is crossed with arbProbability 0: bid %s ask %s", book.Bids[0].Price, book.Asks[0].Price)
+		}
+		for j := 1; j < config.Levels; j++ {
+			if book.Bids[j].Price.GreaterThan(book.Bids[j-1].Price) || book.Asks[j].Price.LessThan(book.Asks[j-1].Price) {
+				t.Fatalf("book %d is out of order at level %d", i, j)
+			}
+		}
+	}
+}
+
+func TestSyntheticBooksCross(t *testing.T) {
+	config := DefaultSyntheticConfig()
+	config.Seed = 1
+	config.ArbProbability = 1
+	pair := *tc.NewPair("btcusd")
+	book := NewSyntheticMarketData(config, []tc.Pair{pair}, testLogger).nextBook(pair)
+	if !book.Bids[0].Price.GreaterThan(book.Asks[0].Price) {
+		t.Errorf("expected a crossed book, got bid %s ask %s", book.Bids[0].Price, book.Asks[0].Price)
+	}
+}
+
+func TestParseCaptureLine(t *testing.T) {
+	raw := `{"sequence":5,"recipient":"orderbook.sfox.btcusd","timestamp":1572925018490611435,"payload":{}}`
+	msg, err := parseCaptureLine([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.Payload) != raw || msg.ReceiptTimestamp.UnixNano() != 1572925018490611435 {
+		t.Errorf("raw message not passed through: %s at %s", msg.Payload, msg.ReceiptTimestamp)
+	}
+	recorded := `{"receiptTimestamp":1572925018490923000,"message":` + raw + `}`
+	msg, err = parseCaptureLine([]byte(recorded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.Payload) != raw || msg.ReceiptTimestamp.UnixNano() != 1572925018490923000 {
+		t.Errorf("recorded message not unwrapped: %s at %s", msg.Payload, msg.ReceiptTimestamp)
+	}
+}
+
+func TestReplayLoopsACaptureThatDoesntStartAtOne(t *testing.T) {
+	f, err := ioutil.TempFile("", "capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	start := time.Now()
+	for sequence := int64(100); sequence < 105; sequence++ {
+		f.Write(capturedMessage(t, "orderbook.sfox.btcusd", sequence, start.Add(time.Duration(sequence)*time.Millisecond)))
+		f.Write([]byte("\n"))
+	}
+	f.Close()
+
+	r := NewReplayMarketData(ReplayConfig{File: f.Name(), Loop: true, Timing: ReplayTimingFast}, StalenessConfig{}, testLogger)
+	books := make(chan sfoxBook)
+	r.Start(books)
+	// three passes' worth
+	for i := 0; i < 15; i++ {
+		select {
+		case <-books:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected every pass to replay the capture's 5 books, got %d", i)
+		}
+	}
+}
+
+func TestSetPairsResubscribesTheOpenConnection(t *testing.T) {
+	received := make(chan string, 10)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- string(msg)
+		}
+	}))
+	defer server.Close()
+
+	btcusd, ethusd := *tc.NewPair("btcusd"), *tc.NewPair("ethusd")
+	md := NewSFOXMarketData(url.URL{Scheme: "ws", Host: strings.TrimPrefix(server.URL, "http://")}, []tc.Pair{btcusd}, testLogger)
+	md.Start(make(chan sfoxBook))
+	expect := func(msg string) {
+		select {
+		case got := <-received:
+			if got != msg {
+				t.Errorf("expected %s, got %s", msg, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected %s to be sent", msg)
+		}
+	}
+	expect(`{"type":"subscribe","feeds":["orderbook.sfox.btcusd"]}`)
+	for i := 0; i < 100; i++ {
+		md.subLock.Lock()
+		open := len(md.conns) > 0 && md.conns[0] != nil
+		md.subLock.Unlock()
+		if open {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	md.SetPairs([]tc.Pair{ethusd})
+	expect(`{"type":"unsubscribe","feeds":["orderbook.sfox.btcusd"]}`)
+	expect(`{"type":"subscribe","feeds":["orderbook.sfox.ethusd"]}`)
+}
+
+func TestMarketDataReconnectsEverySlotWhenOneGoesQuiet(t *testing.T) {
+	var lock sync.Mutex
+	accepted := 0
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		lock.Lock()
+		accepted++
+		n := accepted
+		lock.Unlock()
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+		if n == 2 {
+			// the second connection never gets a message
+			conn.ReadMessage()
+			return
+		}
+		for sequence := int64(1); ; sequence++ {
+			if err := conn.WriteMessage(websocket.TextMessage, capturedMessage(t, "orderbook.sfox.btcusd", sequence, time.Now())); err != nil {
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	md := NewSFOXMarketData(url.URL{Scheme: "ws", Host: strings.TrimPrefix(server.URL, "http://")}, []tc.Pair{*tc.NewPair("btcusd")}, testLogger)
+	md.connections = 2
+	md.deduper = NewFeedDeduper(2)
+	md.health = NewFeedHealth(FeedHealthConfig{
+		StaleAfter:     Duration{time.Second},
+		IdleTimeout:    Duration{time.Second},
+		BackoffInitial: Duration{10 * time.Millisecond},
+		BackoffMax:     Duration{10 * time.Millisecond},
+	})
+	md.staleness = StalenessConfig{}
+	books := make(chan sfoxBook)
+	md.Start(books)
+
+	deadline := time.After(10 * time.Second)
+	for {
+		select {
+		case <-books:
+		case <-deadline:
+			t.Fatal("expected both slots to be reconnected and books to keep coming")
+		}
+		lock.Lock()
+		replaced := accepted >= 4
+		lock.Unlock()
+		if replaced {
+			break
+		}
+	}
+	// the replacements' sequences start over, the books from them still have to get through
+	for i := 0; i < 5; i++ {
+		select {
+		case <-books:
+		case <-deadline:
+			t.Fatal("expected books from the new connections")
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	tc "github.com/ldcicconi/trading-common"
+	"github.com/shopspring/decimal"
+)
+
+// syntheticMarketData is a MarketDataSource that makes up books. Each pair's mid price takes a
+// random walk, and every so often one side is pushed through the other so there's an arb to find.
+type syntheticMarketData struct {
+	config SyntheticConfig
+	pairs  []tc.Pair
+	rand   *rand.Rand
+	mids   map[tc.Pair]float64
+	Logger *log.Logger
+	lock   sync.Mutex // guards pairs and mids, SetPairs can change them while books are being made
+}
+
+type SyntheticConfig struct {
+	Interval       Duration `json:"interval"`       // time between books for each pair
+	Levels         int      `json:"levels"`         // offers per side
+	StartPrice     float64  `json:"startPrice"`     // every pair's mid price starts here
+	SpreadBps      float64  `json:"spreadBps"`      // between the best bid and ask
+	VolatilityBps  float64  `json:"volatilityBps"`  // standard deviation of each step of the mid price
+	ArbProbability float64  `json:"arbProbability"` // chance a book is crossed
+	Seed           int64    `json:"seed"`           // 0 seeds from the clock
+}
+
+func DefaultSyntheticConfig() SyntheticConfig {
+	return SyntheticConfig{
+		Interval:       Duration{500 * time.Millisecond},
+		Levels:         20,
+		StartPrice:     100,
+		SpreadBps:      10,
+		VolatilityBps:  2,
+		ArbProbability: 0.05,
+	}
+}
+
+func (c SyntheticConfig) validate() (errs []error) {
+	if c.Interval.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("marketData.synthetic: interval must be greater than 0"))
+	}
+	if c.Levels <= 0 {
+		errs = append(errs, fmt.Errorf("marketData.synthetic: levels must be greater than 0"))
+	}
+	if c.StartPrice <= 0 {
+		errs = append(errs, fmt.Errorf("marketData.synthetic: startPrice must be greater than 0"))
+	}
+	if c.SpreadBps < 0 || c.VolatilityBps < 0 {
+		errs = append(errs, fmt.Errorf("marketData.synthetic: spreadBps and volatilityBps must not be negative"))
+	}
+	if c.ArbProbability < 0 || c.ArbProbability > 1 {
+		errs = append(errs, fmt.Errorf("marketData.synthetic: arbProbability must be between 0 and 1"))
+	}
+	return errs
+}
+
+var syntheticVenues = []string{"gemini", "itbit", "bitstamp", "bittrex", "market1"}
+
+func NewSyntheticMarketData(config SyntheticConfig, pairs []tc.Pair, logger *log.Logger) *syntheticMarketData {
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	mids := make(map[tc.Pair]float64)
+	for _, pair := range pairs {
+		mids[pair] = config.StartPrice
+	}
+	return &syntheticMarketData{
+		config: config,
+		pairs:  pairs,
+		rand:   rand.New(rand.NewSource(seed)),
+		mids:   mids,
+		Logger: logger,
+	}
+}
+
+func (s *syntheticMarketData) Start(orderbookChan chan sfoxBook) {
+	s.LogInfo(fmt.Sprintf("generating books for %d pairs every %s", len(s.pairs), s.config.Interval.Duration))
+	go func() {
+		ticker := time.NewTicker(s.config.Interval.Duration)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.lock.Lock()
+			pairs := s.pairs
+			s.lock.Unlock()
+			for _, pair := range pairs {
+				orderbookChan <- s.nextBook(pair)
+			}
+		}
+	}()
+}
+
+// SetPairs starts new pairs at StartPrice, and a pair that's dropped and added back starts over
+func (s *syntheticMarketData) SetPairs(pairs []tc.Pair) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	mids := make(map[tc.Pair]float64)
+	for _, pair := range pairs {
+		mid, ok := s.mids[pair]
+		if !ok {
+			mid = s.config.StartPrice
+		}
+		mids[pair] = mid
+	}
+	s.pairs, s.mids = pairs, mids
+	s.LogInfo(fmt.Sprintf("generating books for %d pairs", len(pairs)))
+}
+
+// Status is always FeedLive, the books are made up on the spot
+func (s *syntheticMarketData) Status() feedStatus {
+	return FeedLive
+}
+
+// nextBook moves the pair's mid price a step and builds a book around it
+func (s *syntheticMarketData) nextBook(pair tc.Pair) sfoxBook {
+	s.lock.Lock()
+	mid := s.mids[pair] * (1 + s.rand.NormFloat64()*s.config.VolatilityBps/10000)
+	s.mids[pair] = mid
+	s.lock.Unlock()
+	halfSpread := mid * s.config.SpreadBps / 20000
+	bestBid, bestAsk := mid-halfSpread, mid+halfSpread
+	if s.rand.Float64() < s.config.ArbProbability {
+		// one venue lags, and its bid ends up above everyone else's ask
+		bestBid = bestAsk + mid*(5+s.rand.Float64()*20)/10000
+	}
+	tick := mid / 10000
+	now := time.Now()
+	book := sfoxBook{
+		SFOXOrderbook: tc.SFOXOrderbook{
+			SFOXTimestamp:    now,
+			ReceiptTimestamp: now,
+			Pair:             pair,
+		},
+		VenueTimestamps: make(map[string]time.Time),
+		LastUpdated:     now,
+		LastPublished:   now,
+	}
+	for _, venue := range syntheticVenues {
+		book.VenueTimestamps[venue] = now
+	}
+	for i := 0; i < s.config.Levels; i++ {
+		book.Bids = append(book.Bids, s.offer(bestBid-float64(i)*tick))
+		book.BidVenues = append(book.BidVenues, s.venue())
+		book.Asks = append(book.Asks, s.offer(bestAsk+float64(i)*tick))
+		book.AskVenues = append(book.AskVenues, s.venue())
+	}
+	// the offers are sorted best first, so each venue's first offer is its best
+	book.MarketMaking = make(map[string]venueTop)
+	for i, venue := range book.BidVenues {
+		if top := book.MarketMaking[venue]; !top.HasBid {
+			top.Bid, top.HasBid = book.Bids[i], true
+			book.MarketMaking[venue] = top
+		}
+	}
+	for i, venue := range book.AskVenues {
+		if top := book.MarketMaking[venue]; !top.HasAsk {
+			top.Ask, top.HasAsk = book.Asks[i], true
+			book.MarketMaking[venue] = top
+		}
+	}
+	return book
+}
+
+func (s *syntheticMarketData) offer(price float64) tc.Offer {
+	return tc.Offer{
+		Price:    decimal.NewFromFloat(price).Round(8),
+		Quantity: decimal.NewFromFloat(0.01 + s.rand.ExpFloat64()).Round(8),
+	}
+}
+
+func (s *syntheticMarketData) venue() string {
+	return syntheticVenues[s.rand.Intn(len(syntheticVenues))]
+}
+
+func (s *syntheticMarketData) LogInfo(text string) {
+	s.Logger.Println("[marketdata-synthetic] [info] " + text)
+}
@@ -5,59 +5,271 @@ import (
 	"fmt"
 	"log"
 	"net/url"
+	"sync"
+	"time"
 
 	tc "github.com/ldcicconi/trading-common"
 	ws "github.com/ldcicconi/ws-contractor"
 )
 
+// marketData is the live MarketDataSource, orderbooks from the SFOX websocket feed.
+// It watches the connection and replaces it with a new one, resubscribed, if it goes quiet.
+// With more than one connection, they're all replaced together as soon as any one of them goes quiet,
+// since the feedDeduper can only compare sequences from subscriptions made together.
 type marketData struct {
-	wsWorker *ws.WsContractor
-	Logger   *log.Logger
+	marketURL   url.URL
+	subMessage  []byte // sent by each new connection, guarded by subLock since SetPairs can swap it
+	subLock     sync.Mutex
+	started     bool
+	conns       []*wsConnection // each slot's open connection, nil while it's dialing, guarded by subLock
+	dials       []int           // bumped each time a slot is reconnected, so a slow dial knows it's been abandoned
+	isSecure    bool
+	rawDataChan chan ws.MessageEnvelope
+	connections int              // how many redundant connections there are
+	deduper     *feedDeduper     // nil with a single connection
+	merged      chan slotMessage // every redundant connection's messages, for the deduper
+	health      *feedHealth
+	recorder    *captureRecorder // nil unless recording is configured
+	staleness   StalenessConfig
+	Logger      *log.Logger
 }
 
 func NewMarketData(marketURL url.URL, subMessage []byte, isSecure bool, logger *log.Logger) *marketData {
-	ws := ws.NewWsContractor(marketURL, subMessage, isSecure)
 	return &marketData{
-		wsWorker: ws,
-		Logger:   logger,
+		marketURL:   marketURL,
+		subMessage:  subMessage,
+		isSecure:    isSecure,
+		rawDataChan: make(chan ws.MessageEnvelope),
+		connections: 1,
+		merged:      make(chan slotMessage),
+		health:      NewFeedHealth(DefaultFeedHealthConfig()),
+		staleness:   DefaultStalenessConfig(),
+		Logger:      logger,
 	}
 }
 
 func NewSFOXMarketData(marketURL url.URL, pairs []tc.Pair, logger *log.Logger) *marketData {
-	sfoxSubMessage := GenerateSFOXOrderbookSubMessage(pairs)
-	fmt.Println(sfoxSubMessage)
-	bodyBytes, _ := json.Marshal(sfoxSubMessage)
-	fmt.Println(string(bodyBytes))
-	ws := ws.NewWsContractor(marketURL, bodyBytes, true)
-	return &marketData{
-		wsWorker: ws,
-		Logger:   logger,
+	bodyBytes, _ := json.Marshal(GenerateSFOXOrderbookSubMessage(pairs))
+	md := NewMarketData(marketURL, bodyBytes, true, logger)
+	md.LogInfo("subscribing with " + string(bodyBytes))
+	return md
+}
+
+func (md *marketData) Start(orderbookChan chan sfoxBook) {
+	if md.recorder != nil {
+		if err := md.recorder.Start(); err != nil {
+			md.LogInfo("ERROR starting recorder, continuing without it: " + err.Error())
+			md.recorder = nil
+		}
+	}
+	md.subLock.Lock()
+	md.started = true
+	md.subLock.Unlock()
+	if md.deduper != nil {
+		go md.dedupe()
+		go md.reportConnections()
+	}
+	md.connect()
+	md.ProcessData(md.rawDataChan, orderbookChan)
+	go md.watchConnection()
+}
+
+// dedupe forwards the first copy of each message from the redundant connections. It's the only reader
+// of merged, so the messages it forwards stay in the order they arrived.
+func (md *marketData) dedupe() {
+	for m := range md.merged {
+		if md.deduper.first(m) {
+			md.rawDataChan <- m.msg
+		}
 	}
 }
 
-func (md *marketData) Start(rawDataChan chan ws.MessageEnvelope, orderbookChan chan tc.SFOXOrderbook) {
-	md.wsWorker.Consume(rawDataChan)
-	md.ProcessData(rawDataChan, orderbookChan)
+// reportConnections logs how each redundant connection is doing every minute
+func (md *marketData) reportConnections() {
+	for range time.Tick(time.Minute) {
+		for slot, stats := range md.deduper.Stats() {
+			md.LogInfo(fmt.Sprintf("connection %d: %d messages, first with %.1f%%, mean latency %s, last message %s ago",
+				slot, stats.Messages, stats.WinRate()*100, stats.MeanLatency, time.Since(stats.LastMessage).Truncate(time.Millisecond)))
+		}
+	}
 }
 
-func (md *marketData) LogInfo(text string) {
-	md.Logger.Println("[marketdata] [info] " + text)
+func (md *marketData) Status() feedStatus {
+	return md.health.Status()
+}
+
+// SetPairs changes the orderbook feeds subscribed to. The open connections are sent an unsubscribe for
+// the feeds that were dropped and a subscribe for the new ones, new connections subscribe to pairs.
+// If a connection can't be sent the change, every connection is replaced.
+func (md *marketData) SetPairs(pairs []tc.Pair) {
+	subMessage, _ := json.Marshal(GenerateSFOXOrderbookSubMessage(pairs))
+	md.subLock.Lock()
+	changes := subscriptionChanges(md.subMessage, subMessage)
+	md.subMessage = subMessage
+	started := md.started
+	conns := append([]*wsConnection(nil), md.conns...)
+	md.subLock.Unlock()
+	md.LogInfo("subscription changed to " + string(subMessage))
+	if !started {
+		return
+	}
+	for slot, conn := range conns {
+		if conn == nil {
+			// still dialing, it catches up once it's connected
+			continue
+		}
+		for _, change := range changes {
+			if err := conn.Send(change); err != nil {
+				md.LogInfo(fmt.Sprintf("ERROR resubscribing slot %d, reconnecting: %s", slot, err.Error()))
+				md.health.giveUp()
+				md.connect()
+				return
+			}
+		}
+	}
+}
+
+// subscriptionChanges returns the unsubscribe and subscribe messages that take a connection subscribed
+// with from to the feeds in to
+func subscriptionChanges(from, to []byte) (messages [][]byte) {
+	var before, after SFOXOrderbookSubMessage
+	json.Unmarshal(from, &before)
+	json.Unmarshal(to, &after)
+	subscribed := make(map[string]bool)
+	for _, feed := range before.Feeds {
+		subscribed[feed] = true
+	}
+	var added []string
+	for _, feed := range after.Feeds {
+		if subscribed[feed] {
+			delete(subscribed, feed)
+		} else {
+			added = append(added, feed)
+		}
+	}
+	var dropped []string
+	for _, feed := range before.Feeds {
+		if subscribed[feed] {
+			dropped = append(dropped, feed)
+		}
+	}
+	if len(dropped) > 0 {
+		unsubscribe, _ := json.Marshal(SFOXOrderbookSubMessage{Type: "unsubscribe", Feeds: dropped})
+		messages = append(messages, unsubscribe)
+	}
+	if len(added) > 0 {
+		subscribe, _ := json.Marshal(SFOXOrderbookSubMessage{Type: "subscribe", Feeds: added})
+		messages = append(messages, subscribe)
+	}
+	return messages
 }
 
-func (md *marketData) ProcessData(rawDataChan chan ws.MessageEnvelope, orderbookChan chan tc.SFOXOrderbook) {
+// connect replaces every connection with a new one, sending the subscribe message, and forwards their
+// messages for as long as they're current. The connections being replaced are closed.
+func (md *marketData) connect() {
+	connection := md.health.connecting()
+	if md.deduper != nil {
+		md.deduper.reset(connection)
+	}
+	for slot := 0; slot < md.connections; slot++ {
+		md.connectSlot(connection, slot)
+	}
+}
+
+// connectSlot closes slot's connection, if it has one, and opens a new one. The redundant connections
+// all share the connection id feedHealth follows, so the feed is live as long as any of them is.
+func (md *marketData) connectSlot(connection, slot int) {
+	md.LogInfo(fmt.Sprintf("connecting to %s (connection %d, slot %d)", md.marketURL.String(), connection, slot))
+	if md.deduper != nil {
+		md.deduper.connected(slot, time.Now())
+	}
+	md.subLock.Lock()
+	for len(md.conns) <= slot {
+		md.conns = append(md.conns, nil)
+		md.dials = append(md.dials, 0)
+	}
+	if md.conns[slot] != nil {
+		md.conns[slot].Close()
+		md.conns[slot] = nil
+	}
+	md.dials[slot]++
+	dial := md.dials[slot]
+	subMessage := md.subMessage
+	md.subLock.Unlock()
 	go func() {
-		for msg := range rawDataChan {
-			// md.LogInfo("unmarshalling json")
-			o, err := tc.NewSFOXOrderbookFromJSON(msg.Payload, msg.ReceiptTimestamp)
-			// md.LogInfo("unmarshalling json complete")
-			if err == tc.ErrFirstMessage {
-				md.Logger.Println("Received messsage w/ sequence=1")
-				continue
-			} else if err != nil {
-				md.Logger.Println("ERROR: " + err.Error())
+		conn, err := dialWS(md.marketURL, subMessage, md.isSecure)
+		if err != nil {
+			// the slot stays idle, watchConnection tries again
+			md.LogInfo(fmt.Sprintf("ERROR connecting slot %d: %s", slot, err.Error()))
+			return
+		}
+		md.subLock.Lock()
+		if md.dials[slot] != dial {
+			// reconnected again while this one was dialing
+			md.subLock.Unlock()
+			conn.Close()
+			return
+		}
+		md.conns[slot] = conn
+		// SetPairs skipped this connection while it was dialing
+		changes := subscriptionChanges(subMessage, md.subMessage)
+		md.subLock.Unlock()
+		for _, change := range changes {
+			if err := conn.Send(change); err != nil {
+				md.LogInfo(fmt.Sprintf("ERROR resubscribing slot %d: %s", slot, err.Error()))
+			}
+		}
+		messages := make(chan ws.MessageEnvelope)
+		conn.Consume(messages)
+		for msg := range messages {
+			if !md.health.received(connection, msg.ReceiptTimestamp) {
 				continue
 			}
-			orderbookChan <- *o
+			if md.deduper == nil {
+				md.rawDataChan <- msg
+			} else {
+				md.merged <- slotMessage{slot: slot, connection: connection, msg: msg}
+			}
 		}
 	}()
 }
+
+// watchConnection reconnects when the feed goes idle, backing off exponentially while reconnects
+// keep failing to get any messages, and logs every change in the feed's status
+func (md *marketData) watchConnection() {
+	config := md.health.config
+	backoff := config.BackoffInitial.Duration
+	status := md.Status()
+	for range time.Tick(time.Second) {
+		if md.health.live() {
+			backoff = config.BackoffInitial.Duration
+		}
+		if md.health.idle(time.Now()) {
+			md.health.giveUp()
+			md.LogInfo(fmt.Sprintf("no messages for %s, reconnecting in %s", config.IdleTimeout.Duration, backoff))
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > config.BackoffMax.Duration {
+				backoff = config.BackoffMax.Duration
+			}
+			md.connect()
+		} else if md.deduper != nil {
+			if slots := md.deduper.idleSlots(time.Now(), config.IdleTimeout.Duration); len(slots) > 0 {
+				md.LogInfo(fmt.Sprintf("no messages on slot(s) %v for %s, reconnecting every slot", slots, config.IdleTimeout.Duration))
+				md.connect()
+			}
+		}
+		if newStatus := md.Status(); newStatus != status {
+			md.LogInfo(fmt.Sprintf("feed is %s, was %s", newStatus, status))
+			status = newStatus
+		}
+	}
+}
+
+func (md *marketData) LogInfo(text string) {
+	md.Logger.Println("[marketdata] [info] " + text)
+}
+
+func (md *marketData) ProcessData(rawDataChan chan ws.MessageEnvelope, orderbookChan chan sfoxBook) {
+	go NewFeedProcessor(md.recorder, md.staleness, md.Logger).Run(rawDataChan, orderbookChan)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	tc "github.com/ldcicconi/trading-common"
+	"github.com/shopspring/decimal"
+)
+
+// pairClass holds what differs between pairs quoted in different currencies: SFOX's minimums,
+// our default max order size, and how precisely amounts in the quote currency are reported
+type pairClass struct {
+	Quote            string
+	MinOrderQuantity decimal.Decimal // in base currency
+	MinOrderAmount   decimal.Decimal // in quote currency
+	MaxOrderAmount   decimal.Decimal // ''
+	AmountPrecision  int32
+}
+
+var pairClasses = map[string]pairClass{
+	"usd": {
+		Quote:            "usd",
+		MinOrderQuantity: USDQuotePairMinQuantity,
+		MinOrderAmount:   USDQuotePairMinAmount,
+		MaxOrderAmount:   USDQuotePairMaxAmount,
+		AmountPrecision:  4,
+	},
+	"btc": {
+		Quote:            "btc",
+		MinOrderQuantity: BTCQuotePairMinQuantity,
+		MinOrderAmount:   BTCQuotePairMinAmount,
+		MaxOrderAmount:   BTCQuotePairMaxAmount,
+		AmountPrecision:  8,
+	},
+}
+
+// pairClassOf looks up the class for the pair's quote currency. For an unsupported currency the
+// returned class is still usable for formatting, but has no limits.
+func pairClassOf(pair tc.Pair) (pairClass, error) {
+	quote := strings.ToLower(string(pair.Quote))
+	class, ok := pairClasses[quote]
+	if !ok {
+		return pairClass{Quote: quote, AmountPrecision: 8}, fmt.Errorf("unsupported quote currency %q", string(pair.Quote))
+	}
+	return class, nil
+}
+
+// defaultLimits are the limits every pair in the class starts from before the config file is applied.
+// They're in config file form so the file's layers can be stacked on top of them.
+func (c pairClass) defaultLimits() map[string]interface{} {
+	return map[string]interface{}{
+		"minOrderQuantity": c.MinOrderQuantity.String(),
+		"minOrderAmount":   c.MinOrderAmount.String(),
+		"maxOrderAmount":   c.MaxOrderAmount.String(),
+		"feeRateBps":       smartFee.String(),
+	}
+}
+
+// FormatAmount renders an amount of the quote currency, e.g. "0.00012345 BTC"
+func (c pairClass) FormatAmount(amount decimal.Decimal) string {
+	return amount.StringFixed(c.AmountPrecision) + " " + strings.ToUpper(c.Quote)
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// endpointClass groups SFOX REST calls that share a rate budget
+type endpointClass string
+
+const (
+	EndpointOrder   endpointClass = "order"
+	EndpointCancel  endpointClass = "cancel"
+	EndpointStatus  endpointClass = "status"
+	EndpointBalance endpointClass = "balance"
+)
+
+// highPriority calls are the ones that move money, they're never held back to make room for polling
+func (c endpointClass) highPriority() bool {
+	return c == EndpointOrder || c == EndpointCancel
+}
+
+func (c endpointClass) role() keyRole {
+	if c.highPriority() {
+		return KeyRoleTrade
+	}
+	return KeyRoleRead
+}
+
+type BucketConfig struct {
+	PerSecond float64 `json:"perSecond"`
+	Burst     float64 `json:"burst"`
+}
+
+func (c BucketConfig) validate(name string) (errs []error) {
+	if c.PerSecond <= 0 || c.Burst < 1 {
+		errs = append(errs, fmt.Errorf("rateLimits.%s: perSecond must be greater than 0 and burst at least 1", name))
+	}
+	return errs
+}
+
+// RateLimitsConfig is the request budget for the SFOX REST API. Every call takes a token from its
+// endpoint class's bucket and from its key's bucket. Status and balance polling can't take the last
+// PriorityReserve of a key's burst, that's kept for orders and cancels.
+type RateLimitsConfig struct {
+	Order           BucketConfig `json:"order"`
+	Cancel          BucketConfig `json:"cancel"`
+	Status          BucketConfig `json:"status"`
+	Balance         BucketConfig `json:"balance"`
+	PerKey          BucketConfig `json:"perKey"`
+	PriorityReserve float64      `json:"priorityReserve"` // fraction of PerKey.Burst
+	// after a 429 the key is paused for BackoffInitial, doubling on each 429 in a row up to BackoffMax
+	BackoffInitial Duration `json:"backoffInitial"`
+	BackoffMax     Duration `json:"backoffMax"`
+}
+
+func DefaultRateLimitsConfig() RateLimitsConfig {
+	return RateLimitsConfig{
+		Order:           BucketConfig{PerSecond: 5, Burst: 5},
+		Cancel:          BucketConfig{PerSecond: 5, Burst: 5},
+		Status:          BucketConfig{PerSecond: 4, Burst: 8},
+		Balance:         BucketConfig{PerSecond: 1, Burst: 2},
+		PerKey:          BucketConfig{PerSecond: 5, Burst: 10},
+		PriorityReserve: 0.3,
+		BackoffInitial:  Duration{time.Second},
+		BackoffMax:      Duration{30 * time.Second},
+	}
+}
+
+func (c RateLimitsConfig) validate() (errs []error) {
+	errs = append(errs, c.Order.validate("order")...)
+	errs = append(errs, c.Cancel.validate("cancel")...)
+	errs = append(errs, c.Status.validate("status")...)
+	errs = append(errs, c.Balance.validate("balance")...)
+	errs = append(errs, c.PerKey.validate("perKey")...)
+	if c.PriorityReserve < 0 || c.PriorityReserve >= 1 {
+		errs = append(errs, fmt.Errorf("rateLimits: priorityReserve must be at least 0 and below 1"))
+	}
+	if c.BackoffInitial.Duration <= 0 || c.BackoffMax.Duration < c.BackoffInitial.Duration {
+		errs = append(errs, fmt.Errorf("rateLimits: backoffInitial must be greater than 0 and no more than backoffMax"))
+	}
+	return errs
+}
+
+type tokenBucket struct {
+	perSecond float64
+	burst     float64
+	tokens    float64
+	last      time.Time
+}
+
+func newTokenBucket(c BucketConfig, now time.Time) *tokenBucket {
+	return &tokenBucket{perSecond: c.PerSecond, burst: c.Burst, tokens: c.Burst, last: now}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.perSecond
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// wait is how long until a token can be taken while leaving reserve tokens behind
+func (b *tokenBucket) wait(now time.Time, reserve float64) time.Duration {
+	b.refill(now)
+	missing := 1 + reserve - b.tokens
+	if missing <= 0 {
+		return 0
+	}
+	return time.Duration(missing / b.perSecond * float64(time.Second))
+}
+
+// keyLimits is the per key state: its bucket and any 429 backoff
+type keyLimits struct {
+	bucket      *tokenBucket
+	pausedUntil time.Time
+	backoff     time.Duration
+	rateLimited int // 429s seen, for stats
+}
+
+type rateLimiter struct {
+	config      RateLimitsConfig
+	classes     map[endpointClass]*tokenBucket
+	keys        map[string]*keyLimits
+	highWaiting int // orders and cancels waiting for a token, polling holds back while there are any
+	mtx         sync.Mutex
+}
+
+func NewRateLimiter(config RateLimitsConfig) *rateLimiter {
+	now := time.Now()
+	return &rateLimiter{
+		config: config,
+		classes: map[endpointClass]*tokenBucket{
+			EndpointOrder:   newTokenBucket(config.Order, now),
+			EndpointCancel:  newTokenBucket(config.Cancel, now),
+			EndpointStatus:  newTokenBucket(config.Status, now),
+			EndpointBalance: newTokenBucket(config.Balance, now),
+		},
+		keys: make(map[string]*keyLimits),
+	}
+}
+
+// keyLimitsFor must be called with the lock held
+func (r *rateLimiter) keyLimitsFor(key string, now time.Time) *keyLimits {
+	k, ok := r.keys[key]
+	if !ok {
+		k = &keyLimits{bucket: newTokenBucket(r.config.PerKey, now)}
+		r.keys[key] = k
+	}
+	return k
+}
+
+// Waiting marks a call of class as waiting for a token. For orders and cancels, polling holds back until
+// done is called, so the call that moves money goes first. done should be called as soon as the call has
+// its token, calling it again does nothing.
+func (r *rateLimiter) Waiting(class endpointClass) (done func()) {
+	if !class.highPriority() {
+		return func() {}
+	}
+	r.mtx.Lock()
+	r.highWaiting++
+	r.mtx.Unlock()
+	finished := false
+	return func() {
+		r.mtx.Lock()
+		defer r.mtx.Unlock()
+		if !finished {
+			finished = true
+			r.highWaiting--
+		}
+	}
+}
+
+// TryReserve takes the tokens for a call of class with key if they're all there, otherwise it returns how
+// long to wait before trying again. Callers wait without holding a client, see traderManager.withSFOXClient.
+func (r *rateLimiter) TryReserve(key string, class endpointClass) time.Duration {
+	return r.reserve(key, class, time.Now())
+}
+
+// sleep waits for d, or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reserve takes the tokens for a call if they're all there, otherwise it returns how long to wait before trying again
+func (r *rateLimiter) reserve(key string, class endpointClass, now time.Time) time.Duration {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	k := r.keyLimitsFor(key, now)
+	if now.Before(k.pausedUntil) {
+		return k.pausedUntil.Sub(now)
+	}
+	reserve := 0.0
+	if !class.highPriority() {
+		if r.highWaiting > 0 {
+			// let the waiting order or cancel go first
+			return 10 * time.Millisecond
+		}
+		reserve = r.config.PriorityReserve * k.bucket.burst
+	}
+	classBucket := r.classes[class]
+	wait := classBucket.wait(now, 0)
+	if keyWait := k.bucket.wait(now, reserve); keyWait > wait {
+		wait = keyWait
+	}
+	if wait > 0 {
+		return wait
+	}
+	classBucket.tokens--
+	k.bucket.tokens--
+	return 0
+}
+
+// Observe backs the key off when SFOX says it's being rate limited, and resets the backoff when a call gets through
+func (r *rateLimiter) Observe(key string, err error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	k := r.keyLimitsFor(key, time.Now())
+	if !isRateLimitError(err) {
+		if err == nil {
+			k.backoff = 0
+		}
+		return
+	}
+	if k.backoff == 0 {
+		k.backoff = r.config.BackoffInitial.Duration
+	} else if k.backoff *= 2; k.backoff > r.config.BackoffMax.Duration {
+		k.backoff = r.config.BackoffMax.Duration
+	}
+	k.pausedUntil = time.Now().Add(k.backoff)
+	k.rateLimited++
+}
+
+// RateLimited is how many 429s have been seen across every key
+func (r *rateLimiter) RateLimited() (total int) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for _, k := range r.keys {
+		total += k.rateLimited
+	}
+	return total
+}
+
+func isRateLimitError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if status, ok := httpStatus(err); ok {
+		return status == http.StatusTooManyRequests
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "rate limit") || strings.Contains(msg, "too many requests")
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	sfoxapi "github.com/ldcicconi/sfox-api-lib"
+)
+
+func TestRateLimiterKeepsReserveForOrders(t *testing.T) {
+	config := DefaultRateLimitsConfig()
+	config.Status = BucketConfig{PerSecond: 100, Burst: 100}
+	config.PerKey = BucketConfig{PerSecond: 1, Burst: 10}
+	config.PriorityReserve = 0.3
+	limiter := NewRateLimiter(config)
+	now := time.Now()
+
+	polls := 0
+	for limiter.reserve("key-a", EndpointStatus, now) == 0 {
+		polls++
+	}
+	if polls != 7 {
+		t.Errorf("expected status polling to stop after 7 of 10 tokens, it took %d", polls)
+	}
+	if wait := limiter.reserve("key-a", EndpointOrder, now); wait != 0 {
+		t.Errorf("expected an order to use the reserve straight away, it has to wait %s", wait)
+	}
+	if wait := limiter.reserve("key-b", EndpointStatus, now); wait != 0 {
+		t.Errorf("expected another key to have its own budget, it has to wait %s", wait)
+	}
+}
+
+func TestRateLimiterBacksOffAfter429(t *testing.T) {
+	limiter := NewRateLimiter(DefaultRateLimitsConfig())
+	limiter.Observe("key-a", fmt.Errorf("HTTP 429: too many requests"))
+	if wait := limiter.reserve("key-a", EndpointOrder, time.Now()); wait <= 0 {
+		t.Errorf("expected key-a to be paused after a 429")
+	}
+	limiter.Observe("key-a", fmt.Errorf("429 Too Many Requests"))
+	if backoff := limiter.keys["key-a"].backoff; backoff != 2*time.Second {
+		t.Errorf("expected the backoff to double to 2s, got %s", backoff)
+	}
+	limiter.Observe("key-a", fmt.Errorf("order 4291 not found"))
+	if backoff := limiter.keys["key-a"].backoff; backoff != 2*time.Second {
+		t.Errorf("expected an unrelated error not to change the backoff, got %s", backoff)
+	}
+	limiter.Observe("key-a", nil)
+	if backoff := limiter.keys["key-a"].backoff; backoff != 0 {
+		t.Errorf("expected a successful call to reset the backoff, got %s", backoff)
+	}
+}
+
+func TestRateLimiterHoldsPollingForWaitingOrders(t *testing.T) {
+	limiter := NewRateLimiter(DefaultRateLimitsConfig())
+	done := limiter.Waiting(EndpointOrder)
+	if wait := limiter.TryReserve("key-a", EndpointStatus); wait == 0 {
+		t.Error("expected polling to wait while an order is waiting")
+	}
+	if wait := limiter.TryReserve("key-b", EndpointOrder); wait != 0 {
+		t.Errorf("expected the order to go straight through, it has to wait %s", wait)
+	}
+	done()
+	if wait := limiter.TryReserve("key-a", EndpointStatus); wait != 0 {
+		t.Errorf("expected polling to resume once the order is through, it has to wait %s", wait)
+	}
+}
+
+func TestPollingResumesOnceAnOrderHasItsToken(t *testing.T) {
+	tm := NewTraderManager(testLogger, []APIKey{{Key: "key-a", Roles: []keyRole{KeyRoleTrade}}, {Key: "key-b", Roles: []keyRole{KeyRoleRead}}}, nil, DefaultClientPoolsConfig(), DefaultRateLimitsConfig())
+	err := tm.withSFOXClient(EndpointOrder, func(*sfoxapi.SFOXAPI) error {
+		// the order's request is in flight
+		if wait := tm.rateLimiter.TryReserve("key-b", EndpointStatus); wait != 0 {
+			t.Errorf("expected polling to go ahead while the order's request is made, it has to wait %s", wait)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
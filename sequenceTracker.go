@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// sequenceTracker follows the "sequence" of each feed (recipient). Every SFOX orderbook message is a whole
+// book, so a gap only costs the message that reveals it: that one is dropped, and the next one in sequence
+// is a fresh book that can be used.
+type sequenceTracker struct {
+	feeds map[string]*feedSequence
+	lock  sync.Mutex
+}
+
+type feedSequence struct {
+	last       int64
+	gaps       int
+	missed     int64 // total messages skipped over by gaps
+	duplicates int
+	outOfOrder int
+}
+
+type sequenceResult int
+
+const (
+	sequenceOK         sequenceResult = iota
+	sequenceGap                       // messages were skipped, don't trust this book
+	sequenceDuplicate                 // already seen
+	sequenceOutOfOrder                // older than one already seen
+)
+
+func (r sequenceResult) String() string {
+	switch r {
+	case sequenceOK:
+		return "ok"
+	case sequenceGap:
+		return "gap"
+	case sequenceDuplicate:
+		return "duplicate"
+	case sequenceOutOfOrder:
+		return "out of order"
+	}
+	return "unknown"
+}
+
+type SequenceStats struct {
+	Gaps       int
+	Missed     int64
+	Duplicates int
+	OutOfOrder int
+}
+
+func NewSequenceTracker() *sequenceTracker {
+	return &sequenceTracker{
+		feeds: make(map[string]*feedSequence),
+	}
+}
+
+// Check records sequence for recipient and says whether the message can be used. Sequence 1 starts the
+// feed over, SFOX sends it when a subscription starts.
+func (s *sequenceTracker) Check(recipient string, sequence int64) sequenceResult {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	feed, ok := s.feeds[recipient]
+	if !ok || sequence == 1 {
+		if !ok {
+			feed = &feedSequence{}
+			s.feeds[recipient] = feed
+		}
+		feed.last = sequence
+		return sequenceOK
+	}
+	switch {
+	case sequence == feed.last+1:
+		feed.last = sequence
+		return sequenceOK
+	case sequence > feed.last+1:
+		feed.gaps++
+		feed.missed += sequence - feed.last - 1
+		feed.last = sequence
+		return sequenceGap
+	case sequence == feed.last:
+		feed.duplicates++
+		return sequenceDuplicate
+	default:
+		feed.outOfOrder++
+		return sequenceOutOfOrder
+	}
+}
+
+func (s *sequenceTracker) Stats() map[string]SequenceStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	stats := make(map[string]SequenceStats)
+	for recipient, feed := range s.feeds {
+		stats[recipient] = SequenceStats{
+			Gaps:       feed.gaps,
+			Missed:     feed.missed,
+			Duplicates: feed.duplicates,
+			OutOfOrder: feed.outOfOrder,
+		}
+	}
+	return stats
+}
+
+func (s SequenceStats) String() string {
+	return fmt.Sprintf("%d gaps (%d messages missed), %d duplicates, %d out of order", s.Gaps, s.Missed, s.Duplicates, s.OutOfOrder)
+}
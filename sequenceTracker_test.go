@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestSequenceTracker(t *testing.T) {
+	s := NewSequenceTracker()
+	feed := "orderbook.sfox.btcusd"
+	steps := []struct {
+		sequence int64
+		result   sequenceResult
+	}{
+		{1, sequenceOK},
+		{2, sequenceOK},
+		{5, sequenceGap},
+		{5, sequenceDuplicate},
+		{4, sequenceOutOfOrder},
+		{6, sequenceOK},
+		{1, sequenceOK}, // resubscribed
+		{2, sequenceOK},
+	}
+	for i, step := range steps {
+		if result := s.Check(feed, step.sequence); result != step.result {
+			t.Errorf("step %d: sequence %d was %s, expected %s", i, step.sequence, result, step.result)
+		}
+	}
+	expected := SequenceStats{Gaps: 1, Missed: 2, Duplicates: 1, OutOfOrder: 1}
+	if stats := s.Stats()[feed]; stats != expected {
+		t.Errorf("expected %s, got %s", expected, stats)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"time"
+
+	tc "github.com/ldcicconi/trading-common"
+	"github.com/shopspring/decimal"
+)
+
+// sfoxBook is an SFOX orderbook along with the parts of the payload tc.SFOXOrderbook leaves out.
+// It's what flows from the market data sources to the traders.
+type sfoxBook struct {
+	tc.SFOXOrderbook
+	BidVenues       []string             // the venue of each offer in Bids, nil if unknown
+	AskVenues       []string             // '' Asks
+	VenueTimestamps map[string]time.Time // when SFOX last had data from each venue
+	LastUpdated     time.Time
+	LastPublished   time.Time
+	StaleVenues     []string            // venues older than the staleness limit, see StalenessConfig
+	MarketMaking    map[string]venueTop // each venue's best bid and ask, from the payload's market_making section
+}
+
+// venueTop is one venue's best bid and ask. Either can be missing if the venue only has one side.
+type venueTop struct {
+	Bid    tc.Offer
+	Ask    tc.Offer
+	HasBid bool
+	HasAsk bool
+}
+
+// CrossVenueSpread compares the best bid across venues with the best ask on a different venue.
+// A positive spread means one venue is bidding above where another is offering.
+func (b sfoxBook) CrossVenueSpread() (buyVenue, sellVenue string, spread decimal.Decimal, ok bool) {
+	for bidVenue, bidTop := range b.MarketMaking {
+		if !bidTop.HasBid {
+			continue
+		}
+		for askVenue, askTop := range b.MarketMaking {
+			if askVenue == bidVenue || !askTop.HasAsk {
+				continue
+			}
+			if s := bidTop.Bid.Price.Sub(askTop.Ask.Price); !ok || s.GreaterThan(spread) {
+				buyVenue, sellVenue, spread, ok = askVenue, bidVenue, s, true
+			}
+		}
+	}
+	return buyVenue, sellVenue, spread, ok
+}
+
+// asOf is the book's own idea of now, so ages come out the same live and in replay
+func (b sfoxBook) asOf() time.Time {
+	if !b.LastPublished.IsZero() {
+		return b.LastPublished
+	}
+	return b.SFOXTimestamp
+}
+
+// VenueAge is how old venue's data was when the book was published
+func (b sfoxBook) VenueAge(venue string) (time.Duration, bool) {
+	updated, ok := b.VenueTimestamps[venue]
+	if !ok {
+		return 0, false
+	}
+	return b.asOf().Sub(updated), true
+}
+
+// venuesKnown is true when every offer's venue is known
+func (b sfoxBook) venuesKnown() bool {
+	return b.BidVenues != nil && b.AskVenues != nil && len(b.BidVenues) == len(b.Bids) && len(b.AskVenues) == len(b.Asks)
+}
+
+// withoutVenues returns a copy of the book without the offers from venues drop returns true for,
+// in the book and in MarketMaking.
+// The offers are copied, so the original book is left alone. A book with unknown venues has no way of
+// telling which offers to keep, so every offer is dropped and no arb can be found in it.
+func (b sfoxBook) withoutVenues(drop func(venue string) bool) sfoxBook {
+	if !b.venuesKnown() {
+		b.Bids, b.BidVenues, b.Asks, b.AskVenues, b.MarketMaking = nil, nil, nil, nil, nil
+		return b
+	}
+	filter := func(offers []tc.Offer, venues []string) (keptOffers []tc.Offer, keptVenues []string) {
+		for i, offer := range offers {
+			if !drop(venues[i]) {
+				keptOffers = append(keptOffers, offer)
+				keptVenues = append(keptVenues, venues[i])
+			}
+		}
+		return keptOffers, keptVenues
+	}
+	b.Bids, b.BidVenues = filter(b.Bids, b.BidVenues)
+	b.Asks, b.AskVenues = filter(b.Asks, b.AskVenues)
+	if b.MarketMaking != nil {
+		tops := make(map[string]venueTop)
+		for venue, top := range b.MarketMaking {
+			if !drop(venue) {
+				tops[venue] = top
+			}
+		}
+		b.MarketMaking = tops
+	}
+	return b
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	tc "github.com/ldcicconi/trading-common"
+	"github.com/shopspring/decimal"
+	"github.com/valyala/fastjson"
+)
+
+var errNotABook = fmt.Errorf("message is not an orderbook")
+
+// sfoxParser parses SFOX feed messages in a single pass with fastjson. The parser and its scratch space
+// are reused from message to message, and venue and pair names are interned, so beyond the offers
+// themselves a book costs next to no allocations. It isn't safe for concurrent use.
+type sfoxParser struct {
+	parser fastjson.Parser
+	number []byte
+	names  map[string]string // venues and recipients
+	pairs  map[string]tc.Pair
+}
+
+// sfoxMessage is a parsed feed message. Its book is only built on request, and only until the parser's next message.
+type sfoxMessage struct {
+	Sequence  int64
+	Recipient string
+	value     *fastjson.Value
+	parser    *sfoxParser
+}
+
+func NewSFOXParser() *sfoxParser {
+	return &sfoxParser{
+		names: make(map[string]string),
+		pairs: make(map[string]tc.Pair),
+	}
+}
+
+func (p *sfoxParser) Parse(raw []byte) (sfoxMessage, error) {
+	v, err := p.parser.ParseBytes(raw)
+	if err != nil {
+		return sfoxMessage{}, err
+	}
+	return sfoxMessage{
+		Sequence:  v.GetInt64("sequence"),
+		Recipient: p.intern(v.GetStringBytes("recipient")),
+		value:     v,
+		parser:    p,
+	}, nil
+}
+
+// Book builds the message's book, the same one tc.NewSFOXOrderbookFromJSON would along with the venue
+// details it leaves out. Like tc, it returns tc.ErrFirstMessage for the message that starts a feed.
+func (m sfoxMessage) Book(receipt time.Time) (sfoxBook, error) {
+	if m.Sequence == 1 {
+		return sfoxBook{}, tc.ErrFirstMessage
+	}
+	payload := m.value.Get("payload")
+	if payload == nil || payload.Type() != fastjson.TypeObject {
+		return sfoxBook{}, errNotABook
+	}
+	p := m.parser
+	pair, ok := p.pairs[string(payload.GetStringBytes("pair"))]
+	if !ok {
+		name := string(payload.GetStringBytes("pair"))
+		pair = *tc.NewPair(name)
+		p.pairs[name] = pair
+	}
+	book := sfoxBook{
+		SFOXOrderbook: tc.SFOXOrderbook{
+			SFOXTimestamp:    time.Unix(0, m.value.GetInt64("timestamp")),
+			ReceiptTimestamp: receipt,
+			Pair:             pair,
+		},
+		VenueTimestamps: make(map[string]time.Time),
+		LastUpdated:     msTime(payload.GetInt64("lastupdated")),
+		LastPublished:   msTime(payload.GetInt64("lastpublished")),
+	}
+	var err error
+	if book.Bids, book.BidVenues, err = p.offers(payload.GetArray("bids")); err != nil {
+		return sfoxBook{}, fmt.Errorf("bids: %s", err.Error())
+	}
+	if book.Asks, book.AskVenues, err = p.offers(payload.GetArray("asks")); err != nil {
+		return sfoxBook{}, fmt.Errorf("asks: %s", err.Error())
+	}
+	if book.MarketMaking, err = p.marketMaking(payload.Get("market_making")); err != nil {
+		return sfoxBook{}, fmt.Errorf("market_making: %s", err.Error())
+	}
+	payload.GetObject("timestamps").Visit(func(venue []byte, v *fastjson.Value) {
+		// the latest of the venue's timestamps is when its data was last updated
+		var latest int64
+		for _, ts := range v.GetArray() {
+			if n := ts.GetInt64(); n > latest {
+				latest = n
+			}
+		}
+		book.VenueTimestamps[p.intern(venue)] = msTime(latest)
+	})
+	return book, nil
+}
+
+// offers reads [price, quantity, venue] levels
+func (p *sfoxParser) offers(levels []*fastjson.Value) ([]tc.Offer, []string, error) {
+	offers := make([]tc.Offer, len(levels))
+	venues := make([]string, len(levels))
+	for i, level := range levels {
+		fields := level.GetArray()
+		if len(fields) < 2 {
+			return nil, nil, fmt.Errorf("level %d has %d fields", i, len(fields))
+		}
+		var err error
+		if offers[i].Price, err = p.decimal(fields[0]); err != nil {
+			return nil, nil, err
+		}
+		if offers[i].Quantity, err = p.decimal(fields[1]); err != nil {
+			return nil, nil, err
+		}
+		if len(fields) > 2 {
+			venues[i] = p.intern(fields[2].GetStringBytes())
+		}
+	}
+	return offers, venues, nil
+}
+
+// marketMaking reads the market_making section, one best bid and ask per venue in no particular order
+func (p *sfoxParser) marketMaking(v *fastjson.Value) (map[string]venueTop, error) {
+	tops := make(map[string]venueTop)
+	if v == nil {
+		return tops, nil
+	}
+	bids, bidVenues, err := p.offers(v.GetArray("bids"))
+	if err != nil {
+		return nil, err
+	}
+	asks, askVenues, err := p.offers(v.GetArray("asks"))
+	if err != nil {
+		return nil, err
+	}
+	for i, venue := range bidVenues {
+		top := tops[venue]
+		if !top.HasBid || bids[i].Price.GreaterThan(top.Bid.Price) {
+			top.Bid, top.HasBid = bids[i], true
+		}
+		tops[venue] = top
+	}
+	for i, venue := range askVenues {
+		top := tops[venue]
+		if !top.HasAsk || asks[i].Price.LessThan(top.Ask.Price) {
+			top.Ask, top.HasAsk = asks[i], true
+		}
+		tops[venue] = top
+	}
+	return tops, nil
+}
+
+// decimal parses a JSON number exactly as written, not through a float
+func (p *sfoxParser) decimal(v *fastjson.Value) (decimal.Decimal, error) {
+	if v.Type() != fastjson.TypeNumber {
+		return decimal.Zero, fmt.Errorf("expected a number")
+	}
+	p.number = v.MarshalTo(p.number[:0])
+	return decimal.NewFromString(string(p.number))
+}
+
+// intern returns a string equal to b, the same one every time, so a name seen before doesn't allocate
+func (p *sfoxParser) intern(b []byte) string {
+	if s, ok := p.names[string(b)]; ok {
+		return s
+	}
+	s := string(b)
+	p.names[s] = s
+	return s
+}
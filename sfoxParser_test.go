@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	tc "github.com/ldcicconi/trading-common"
+)
+
+func loadCapturedMessages(tb testing.TB) [][]byte {
+	f, err := os.Open("testdata/sfox_btcusd.jsonl")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer f.Close()
+	var messages [][]byte
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), replayMaxLineSize)
+	for scanner.Scan() {
+		messages = append(messages, append([]byte(nil), scanner.Bytes()...))
+	}
+	if len(messages) == 0 {
+		tb.Fatal("no captured messages")
+	}
+	return messages
+}
+
+func TestSFOXParserMatchesTC(t *testing.T) {
+	p := NewSFOXParser()
+	receipt := time.Now()
+	for i, raw := range loadCapturedMessages(t) {
+		expected, err := tc.NewSFOXOrderbookFromJSON(raw, receipt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := p.Parse(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		book, err := msg.Book(receipt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if book.Pair != expected.Pair || !book.SFOXTimestamp.Equal(expected.SFOXTimestamp) || !book.ReceiptTimestamp.Equal(expected.ReceiptTimestamp) {
+			t.Errorf("message %d: got %s at %s, expected %s at %s", i, book.Pair, book.SFOXTimestamp, expected.Pair, expected.SFOXTimestamp)
+		}
+		compare := func(side string, got, want []tc.Offer) {
+			if len(got) != len(want) {
+				t.Fatalf("message %d: %d %s, expected %d", i, len(got), side, len(want))
+			}
+			for j := range want {
+				if !got[j].Price.Equal(want[j].Price) || !got[j].Quantity.Equal(want[j].Quantity) {
+					t.Errorf("message %d: %s[%d] is %s@%s, expected %s@%s", i, side, j, got[j].Quantity, got[j].Price, want[j].Quantity, want[j].Price)
+				}
+			}
+		}
+		compare("bids", book.Bids, expected.Bids)
+		compare("asks", book.Asks, expected.Asks)
+	}
+}
+
+func TestSFOXParserVenueDetails(t *testing.T) {
+	msg, err := NewSFOXParser().Parse(loadCapturedMessages(t)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Sequence != 5 || msg.Recipient != "orderbook.sfox.btcusd" {
+		t.Errorf("unexpected envelope: %d %s", msg.Sequence, msg.Recipient)
+	}
+	book, err := msg.Book(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.BidVenues[0] != "itbit" || book.AskVenues[0] != "market1" || len(book.BidVenues) != len(book.Bids) {
+		t.Errorf("venues don't line up with offers: %v... %v...", book.BidVenues[:2], book.AskVenues[:2])
+	}
+	if book.LastPublished.UnixNano()/int64(time.Millisecond) != 1572925018452 {
+		t.Errorf("unexpected lastpublished %s", book.LastPublished)
+	}
+	if age, _ := book.VenueAge("itbit"); age != 822*time.Millisecond {
+		t.Errorf("expected itbit to be 822ms old, got %s", age)
+	}
+	gemini := book.MarketMaking["gemini"]
+	if !gemini.HasBid || !gemini.HasAsk || gemini.Bid.Price.String() != "9420.71" || gemini.Ask.Price.String() != "9423.32" {
+		t.Errorf("unexpected gemini top of book: %+v", gemini)
+	}
+	if len(book.MarketMaking) != 5 {
+		t.Errorf("expected a top of book for 5 venues, got %d", len(book.MarketMaking))
+	}
+	buyVenue, sellVenue, spread, ok := book.CrossVenueSpread()
+	if !ok || buyVenue != "market1" || sellVenue != "itbit" || spread.String() != "6" {
+		t.Errorf("expected to buy on market1 and sell on itbit for 6, got %s %s %s", buyVenue, sellVenue, spread)
+	}
+	first, _ := NewSFOXParser().Parse([]byte(`{"sequence":1,"recipient":"orderbook.sfox.btcusd","payload":{}}`))
+	if _, err := first.Book(time.Now()); err != tc.ErrFirstMessage {
+		t.Errorf("expected ErrFirstMessage, got %v", err)
+	}
+}
+
+// BenchmarkParseTC is the parsing the feed did before sfoxParser: tc for the book, and encoding/json for the rest
+func BenchmarkParseTC(b *testing.B) {
+	messages := loadCapturedMessages(b)
+	var extras struct {
+		Sequence  int64  `json:"sequence"`
+		Recipient string `json:"recipient"`
+		Payload   struct {
+			Bids          [][]interface{}    `json:"bids"`
+			Asks          [][]interface{}    `json:"asks"`
+			Timestamps    map[string][]int64 `json:"timestamps"`
+			LastUpdated   int64              `json:"lastupdated"`
+			LastPublished int64              `json:"lastpublished"`
+		} `json:"payload"`
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		raw := messages[i%len(messages)]
+		if err := json.Unmarshal(raw, &extras); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := tc.NewSFOXOrderbookFromJSON(raw, time.Now()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseFastjson(b *testing.B) {
+	messages := loadCapturedMessages(b)
+	p := NewSFOXParser()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		msg, err := p.Parse(messages[i%len(messages)])
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := msg.Book(time.Now()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	sfoxapi "github.com/ldcicconi/sfox-api-lib"
+	tc "github.com/ldcicconi/trading-common"
+	"github.com/shopspring/decimal"
+)
+
+// orderVenue is where traders send their orders. A *sfoxapi.SFOXAPI is one, the simulatedVenue is the other.
+type orderVenue interface {
+	NewOrder(quantity, price decimal.Decimal, algoID int, pair, side string) (sfoxapi.OrderStatusResponse, error)
+	OrderStatus(id int64) (sfoxapi.OrderStatusResponse, error)
+	CancelOrder(id int64) error
+}
+
+// simulatedVenue fills orders against the books flowing through the app instead of sending them to SFOX.
+// An order fills against each book at most once, at the prices in that book that are within its limit.
+// Our own fills don't take liquidity out of later books, so results are optimistic for size.
+type simulatedVenue struct {
+	feeRate  decimal.Decimal
+	books    map[string]tc.SFOXOrderbook // latest book per pair
+	orders   map[int64]*simulatedOrder
+	nextID   int64
+	balances map[tc.Currency]decimal.Decimal
+	Logger   *log.Logger
+	lock     sync.Mutex
+}
+
+type simulatedOrder struct {
+	pair     string
+	side     string
+	quantity decimal.Decimal
+	price    decimal.Decimal
+	lastBook time.Time // SFOXTimestamp of the last book the order was filled against
+	status   sfoxapi.OrderStatusResponse
+	canceled bool
+}
+
+// SimulationConfig sets up the simulated venue used when the market data isn't live
+type SimulationConfig struct {
+	FeeRateBps float64            `json:"feeRateBps"`
+	Balances   map[string]float64 `json:"balances"` // starting balances by currency
+}
+
+func DefaultSimulationConfig() SimulationConfig {
+	return SimulationConfig{
+		FeeRateBps: 17.5,
+		Balances:   map[string]float64{"usd": 1000, "btc": 1},
+	}
+}
+
+func (c SimulationConfig) validate() (errs []error) {
+	if c.FeeRateBps < 0 {
+		errs = append(errs, fmt.Errorf("simulation: feeRateBps must not be negative"))
+	}
+	for currency, balance := range c.Balances {
+		if balance < 0 {
+			errs = append(errs, fmt.Errorf("simulation: balance for %s must not be negative", currency))
+		}
+	}
+	return errs
+}
+
+func NewSimulatedVenue(config SimulationConfig, logger *log.Logger) *simulatedVenue {
+	balances := make(map[tc.Currency]decimal.Decimal)
+	for currency, balance := range config.Balances {
+		balances[tc.Currency(currency)] = decimal.NewFromFloat(balance)
+	}
+	return &simulatedVenue{
+		feeRate:  decimal.NewFromFloat(config.FeeRateBps).Div(decimal.New(1, 4)),
+		books:    make(map[string]tc.SFOXOrderbook),
+		orders:   make(map[int64]*simulatedOrder),
+		balances: balances,
+		Logger:   logger,
+	}
+}
+
+// UpdateBook gives the venue the latest book for its pair
+func (v *simulatedVenue) UpdateBook(o sfoxBook) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.books[o.Pair.String()] = o.SFOXOrderbook
+}
+
+func (v *simulatedVenue) NewOrder(quantity, price decimal.Decimal, algoID int, pair, side string) (sfoxapi.OrderStatusResponse, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if side != string(tc.SIDE_BUY) && side != string(tc.SIDE_SELL) {
+		return sfoxapi.OrderStatusResponse{}, fmt.Errorf("simulated venue: unknown side %q", side)
+	}
+	v.nextID++
+	order := &simulatedOrder{
+		pair:     pair,
+		side:     side,
+		quantity: quantity,
+		price:    price,
+		status:   sfoxapi.OrderStatusResponse{ID: v.nextID, Status: "Started"},
+	}
+	v.orders[order.status.ID] = order
+	v.LogInfo(fmt.Sprintf("order %d: %s %s %s @ %s", order.status.ID, side, quantity, pair, price))
+	return order.status, nil
+}
+
+func (v *simulatedVenue) OrderStatus(id int64) (sfoxapi.OrderStatusResponse, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	order, ok := v.orders[id]
+	if !ok {
+		return sfoxapi.OrderStatusResponse{}, fmt.Errorf("simulated venue: no order %d", id)
+	}
+	if !order.canceled && order.status.Status != "Done" {
+		v.fill(order)
+	}
+	return order.status, nil
+}
+
+func (v *simulatedVenue) CancelOrder(id int64) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	order, ok := v.orders[id]
+	if !ok {
+		return fmt.Errorf("simulated venue: no order %d", id)
+	}
+	if order.status.Status == "Done" {
+		return fmt.Errorf("simulated venue: order %d is already done", id)
+	}
+	order.canceled = true
+	order.status.Status = "Canceled"
+	v.LogInfo(fmt.Sprintf("order %d canceled, filled %s of %s", id, order.status.FilledQuantity, order.quantity))
+	return nil
+}
+
+// Balances returns what's available in each currency, after the simulated fills so far
+func (v *simulatedVenue) Balances() map[tc.Currency]decimal.Decimal {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	balances := make(map[tc.Currency]decimal.Decimal)
+	for currency, balance := range v.balances {
+		balances[currency] = balance
+	}
+	return balances
+}
+
+// fill matches what's left of order against the latest book, if it hasn't seen that book already.
+// It must be called with the lock held.
+func (v *simulatedVenue) fill(order *simulatedOrder) {
+	book, ok := v.books[order.pair]
+	if !ok || !book.SFOXTimestamp.After(order.lastBook) {
+		return
+	}
+	order.lastBook = book.SFOXTimestamp
+	offers, crosses := book.Asks, func(p decimal.Decimal) bool { return p.LessThanOrEqual(order.price) }
+	if order.side == string(tc.SIDE_SELL) {
+		offers, crosses = book.Bids, func(p decimal.Decimal) bool { return p.GreaterThanOrEqual(order.price) }
+	}
+	remaining := order.quantity.Sub(order.status.FilledQuantity)
+	filled, notional := decimal.Zero, decimal.Zero
+	for _, offer := range offers {
+		if !remaining.GreaterThan(decimal.Zero) || !crosses(offer.Price) {
+			break
+		}
+		q := decimal.Min(remaining, offer.Quantity)
+		filled = filled.Add(q)
+		notional = notional.Add(q.Mul(offer.Price))
+		remaining = remaining.Sub(q)
+	}
+	if filled.IsZero() {
+		return
+	}
+	fee := notional.Mul(v.feeRate)
+	pair := book.Pair
+	if order.side == string(tc.SIDE_BUY) {
+		order.status.NetProceeds = order.status.NetProceeds.Sub(notional.Add(fee))
+		v.balances[pair.Quote] = v.balances[pair.Quote].Sub(notional.Add(fee))
+		v.balances[pair.Base] = v.balances[pair.Base].Add(filled)
+	} else {
+		order.status.NetProceeds = order.status.NetProceeds.Add(notional.Sub(fee))
+		v.balances[pair.Quote] = v.balances[pair.Quote].Add(notional.Sub(fee))
+		v.balances[pair.Base] = v.balances[pair.Base].Sub(filled)
+	}
+	order.status.FilledQuantity = order.status.FilledQuantity.Add(filled)
+	if !remaining.GreaterThan(decimal.Zero) {
+		order.status.Status = "Done"
+	}
+	v.LogInfo(fmt.Sprintf("order %d filled %s @ avg %s, %s of %s done", order.status.ID, filled, notional.Div(filled).StringFixed(8), order.status.FilledQuantity, order.quantity))
+}
+
+func (v *simulatedVenue) LogInfo(text string) {
+	v.Logger.Println("[simulated-venue] [info] " + text)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	tc "github.com/ldcicconi/trading-common"
+	"github.com/shopspring/decimal"
+)
+
+func TestSimulatedVenueFillsAgainstBooks(t *testing.T) {
+	v := NewSimulatedVenue(SimulationConfig{FeeRateBps: 10, Balances: map[string]float64{"usd": 1000}}, testLogger)
+	pair := *tc.NewPair("btcusd")
+	book := func(at time.Time, askQuantity int64) sfoxBook {
+		o := sfoxBook{SFOXOrderbook: tc.SFOXOrderbook{Pair: pair, SFOXTimestamp: at}}
+		o.Asks = []tc.Offer{
+			{Price: decimal.New(100, 0), Quantity: decimal.New(askQuantity, 0)},
+			{Price: decimal.New(105, 0), Quantity: decimal.New(10, 0)},
+		}
+		return o
+	}
+	start := time.Now()
+	v.UpdateBook(book(start, 1))
+	order, err := v.NewOrder(decimal.New(2, 0), decimal.New(101, 0), 200, pair.String(), string(tc.SIDE_BUY))
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, _ := v.OrderStatus(order.ID)
+	if !status.FilledQuantity.Equal(decimal.New(1, 0)) || status.Status != "Started" {
+		t.Fatalf("expected a partial fill of 1, got %+v", status)
+	}
+	// the same book can't fill it twice
+	status, _ = v.OrderStatus(order.ID)
+	if !status.FilledQuantity.Equal(decimal.New(1, 0)) {
+		t.Fatalf("filled twice against the same book: %+v", status)
+	}
+	v.UpdateBook(book(start.Add(time.Second), 1))
+	status, _ = v.OrderStatus(order.ID)
+	if status.Status != "Done" || !status.NetProceeds.Equal(decimal.NewFromFloat(-200.2)) {
+		t.Fatalf("expected the order to be done for 200 plus fees, got %+v", status)
+	}
+	balances := v.Balances()
+	if !balances["usd"].Equal(decimal.NewFromFloat(799.8)) || !balances["btc"].Equal(decimal.New(2, 0)) {
+		t.Errorf("unexpected balances after the buy: %v", balances)
+	}
+	if err := v.CancelOrder(order.ID); err == nil {
+		t.Errorf("expected an error canceling a filled order")
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	StaleVenueDrop = "drop" // take the venue's offers out of the book
+	StaleVenueFlag = "flag" // leave them in, but list the venue in StaleVenues
+)
+
+// StalenessConfig limits how old a venue's data can be, relative to when SFOX published the book
+type StalenessConfig struct {
+	MaxVenueAge Duration `json:"maxVenueAge"` // 0 turns the check off
+	Action      string   `json:"action"`      // drop or flag
+}
+
+func DefaultStalenessConfig() StalenessConfig {
+	return StalenessConfig{
+		MaxVenueAge: Duration{5 * time.Second},
+		Action:      StaleVenueDrop,
+	}
+}
+
+func (c StalenessConfig) validate() (errs []error) {
+	if c.MaxVenueAge.Duration < 0 {
+		errs = append(errs, fmt.Errorf("marketData.staleness: maxVenueAge must not be negative"))
+	}
+	if c.Action != StaleVenueDrop && c.Action != StaleVenueFlag {
+		errs = append(errs, fmt.Errorf("marketData.staleness: unknown action %q, expected drop or flag", c.Action))
+	}
+	return errs
+}
+
+// stalenessChecker applies a StalenessConfig to books and counts how often each venue was stale
+type stalenessChecker struct {
+	config StalenessConfig
+	counts map[string]int
+	// books with stale venues that couldn't be filtered because their venues are unknown, all their offers are dropped
+	unknownVenues int
+	lock          sync.Mutex
+}
+
+func NewStalenessChecker(config StalenessConfig) *stalenessChecker {
+	return &stalenessChecker{
+		config: config,
+		counts: make(map[string]int),
+	}
+}
+
+func (s *stalenessChecker) Check(b sfoxBook) sfoxBook {
+	if s.config.MaxVenueAge.Duration <= 0 {
+		return b
+	}
+	stale := make(map[string]bool)
+	for venue := range b.VenueTimestamps {
+		if age, _ := b.VenueAge(venue); age > s.config.MaxVenueAge.Duration {
+			stale[venue] = true
+			b.StaleVenues = append(b.StaleVenues, venue)
+		}
+	}
+	if len(stale) == 0 {
+		return b
+	}
+	s.lock.Lock()
+	for venue := range stale {
+		s.counts[venue]++
+	}
+	if s.config.Action == StaleVenueDrop && !b.venuesKnown() {
+		s.unknownVenues++
+	}
+	s.lock.Unlock()
+	if s.config.Action == StaleVenueDrop {
+		b = b.withoutVenues(func(venue string) bool { return stale[venue] })
+	}
+	return b
+}
+
+// UnknownVenues returns how many books had stale venues but no venue for each offer, and were emptied rather than filtered
+func (s *stalenessChecker) UnknownVenues() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.unknownVenues
+}
+
+// Counts returns how many books each venue has been stale in
+func (s *stalenessChecker) Counts() map[string]int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	counts := make(map[string]int)
+	for venue, n := range s.counts {
+		counts[venue] = n
+	}
+	return counts
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	tc "github.com/ldcicconi/trading-common"
+	"github.com/shopspring/decimal"
+)
+
+func testVenueBook(published time.Time, venueAges map[string]time.Duration) sfoxBook {
+	offer := tc.Offer{Price: decimal.New(100, 0), Quantity: decimal.New(1, 0)}
+	b := sfoxBook{
+		SFOXOrderbook:   tc.SFOXOrderbook{Pair: *tc.NewPair("btcusd")},
+		VenueTimestamps: make(map[string]time.Time),
+		LastPublished:   published,
+	}
+	for _, venue := range []string{"gemini", "itbit", "gemini"} {
+		b.Bids = append(b.Bids, offer)
+		b.BidVenues = append(b.BidVenues, venue)
+		b.Asks = append(b.Asks, offer)
+		b.AskVenues = append(b.AskVenues, venue)
+	}
+	for venue, age := range venueAges {
+		b.VenueTimestamps[venue] = published.Add(-age)
+	}
+	return b
+}
+
+func TestStalenessCheckerDropsStaleVenues(t *testing.T) {
+	// a book from long ago, so the check has to go by the book's own time
+	published := time.Date(2019, 11, 5, 3, 36, 58, 0, time.UTC)
+	b := testVenueBook(published, map[string]time.Duration{"gemini": time.Second, "itbit": 10 * time.Second})
+	s := NewStalenessChecker(StalenessConfig{MaxVenueAge: Duration{5 * time.Second}, Action: StaleVenueDrop})
+	checked := s.Check(b)
+	if !reflect.DeepEqual(checked.BidVenues, []string{"gemini", "gemini"}) || !reflect.DeepEqual(checked.AskVenues, []string{"gemini", "gemini"}) || len(checked.Bids) != 2 {
+		t.Errorf("expected itbit to be dropped, got bids from %v and asks from %v", checked.BidVenues, checked.AskVenues)
+	}
+	if !reflect.DeepEqual(checked.StaleVenues, []string{"itbit"}) {
+		t.Errorf("expected itbit to be listed as stale, got %v", checked.StaleVenues)
+	}
+	if len(b.Bids) != 3 {
+		t.Errorf("the original book was modified")
+	}
+	if s.Counts()["itbit"] != 1 {
+		t.Errorf("expected itbit's staleness to be counted, got %v", s.Counts())
+	}
+
+	s = NewStalenessChecker(StalenessConfig{MaxVenueAge: Duration{5 * time.Second}, Action: StaleVenueFlag})
+	checked = s.Check(b)
+	if len(checked.Bids) != 3 || !reflect.DeepEqual(checked.StaleVenues, []string{"itbit"}) {
+		t.Errorf("expected itbit to be flagged but kept, got %+v", checked)
+	}
+}
+
+func TestStalenessCheckerFailsClosedWithoutVenues(t *testing.T) {
+	published := time.Now()
+	b := testVenueBook(published, map[string]time.Duration{"itbit": 10 * time.Second})
+	b.BidVenues, b.AskVenues = nil, nil
+	s := NewStalenessChecker(StalenessConfig{MaxVenueAge: Duration{5 * time.Second}, Action: StaleVenueDrop})
+	checked := s.Check(b)
+	if len(checked.Bids) != 0 || len(checked.Asks) != 0 {
+		t.Errorf("expected every offer to be dropped when the stale venue's offers can't be told apart, got %d bids and %d asks", len(checked.Bids), len(checked.Asks))
+	}
+	if s.UnknownVenues() != 1 {
+		t.Errorf("expected the book to be counted, got %d", s.UnknownVenues())
+	}
+}
+
+func TestFullyStaleBookIsNoArb(t *testing.T) {
+	published := time.Now()
+	b := testVenueBook(published, map[string]time.Duration{"gemini": 10 * time.Second, "itbit": 10 * time.Second})
+	checked := NewStalenessChecker(StalenessConfig{MaxVenueAge: Duration{5 * time.Second}, Action: StaleVenueDrop}).Check(b)
+	if len(checked.Bids) != 0 || len(checked.Asks) != 0 {
+		t.Fatalf("expected every offer to be dropped, got %d bids and %d asks", len(checked.Bids), len(checked.Asks))
+	}
+	if _, err := FindArb(checked.SFOXOrderbook, TradeLimits{}, decimal.New(1000, 0)); err != errNoArb {
+		t.Errorf("expected no arb in an empty book, got %v", err)
+	}
+	tm := &traderManager{Logger: testLogger, balances: NewSafeBalanceMap(), traders: make(map[tc.Pair]*Trader)}
+	NewTrader(TraderConfig{Pair: b.Pair}, testLogger, tm).handleOrderbook(checked)
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/shopspring/decimal"
@@ -13,22 +14,33 @@ import (
 )
 
 type Trader struct {
-	OrderbookChan       chan tc.SFOXOrderbook // the Trader receives orderbooks from the TraderManager through this channel
-	Config              TraderConfig
+	Books               *bookSlot    // the Trader picks up the latest book from the TraderManager here
+	Config              TraderConfig // Config.TradeLimits and Config.Venues can be swapped at runtime, read them through TradeLimits() and VenueRules()
+	limitsMtx           sync.RWMutex
+	class               pairClass // configs are validated before traders are created, so the class is always known
 	Logger              *log.Logger
 	manager             *traderManager
 	errCount            int
 	arbChan             chan arbStrat
 	noArbChan           chan struct{}
-	killChan            chan bool                        // the arbMonitor loop listens on this, and will exit the position if signalled
+	killChan            chan bool                        // the arbMonitor loop listens on this, and will exit the position if signalled. Closed by Stop.
 	buyOrderStatusChan  chan sfoxapi.OrderStatusResponse // a goroutine notifies the main arbMonitor of buy order updates through this chan
 	sellOrderStatusChan chan sfoxapi.OrderStatusResponse
+	stopOnce            sync.Once
+	lifecycleMtx        sync.Mutex
+	started             bool          // set by Start, guarded by lifecycleMtx
+	done                chan struct{} // closed once trade has returned, after unwinding
+	unwindTimeout       time.Duration // how long Stop waits for the unwind's sell before canceling it
+	unknownVenueBooks   int           // books the venue rules couldn't be applied to, so no arb was looked for
+	unknownVenueLogged  time.Time     // when unknownVenueBooks was last logged, it's logged at most once a minute
 }
 
 func NewTrader(config TraderConfig, logger *log.Logger, manager *traderManager) *Trader {
+	class, _ := pairClassOf(config.Pair)
 	return &Trader{
-		OrderbookChan:       make(chan tc.SFOXOrderbook),
+		Books:               NewBookSlot(),
 		Config:              config,
+		class:               class,
 		Logger:              logger,
 		manager:             manager,
 		arbChan:             make(chan arbStrat),
@@ -36,17 +48,50 @@ func NewTrader(config TraderConfig, logger *log.Logger, manager *traderManager)
 		killChan:            make(chan bool),
 		buyOrderStatusChan:  make(chan sfoxapi.OrderStatusResponse),
 		sellOrderStatusChan: make(chan sfoxapi.OrderStatusResponse),
+		done:                make(chan struct{}),
+		unwindTimeout:       defaultUnwindTimeout,
 	}
 }
 
+// a sell that hasn't filled by then is left for someone to deal with, so removing a trader can't hang a reload
+const defaultUnwindTimeout = time.Minute
+
 func (t *Trader) Start() {
+	t.lifecycleMtx.Lock()
+	t.started = true
+	t.lifecycleMtx.Unlock()
 	t.monitorOrderbooks()
 	t.trade()
 }
 
+// Stop stops the trader looking at books and unwinds the open arb, if there is one: an open buy is
+// canceled, and whatever it filled is sold, or left to the exit sell if that's already open.
+// It returns once the sell is done, or when there was nothing to sell. A sell that isn't done within
+// unwindTimeout is canceled, and what's left of it is logged as needing to be sold manually.
+func (t *Trader) Stop() {
+	t.stopOnce.Do(func() {
+		close(t.killChan)
+	})
+	t.lifecycleMtx.Lock()
+	started := t.started
+	t.lifecycleMtx.Unlock()
+	if started {
+		<-t.done
+	}
+}
+
 func (t *Trader) monitorOrderbooks() {
 	go func() {
-		for o := range t.OrderbookChan {
+		for {
+			select {
+			case <-t.killChan:
+				return
+			case <-t.Books.Ready():
+			}
+			o, ok := t.Books.Take()
+			if !ok {
+				continue
+			}
 			// t.infof(o.DescribeArb(t.Config.FeeRateBps))
 			t.handleOrderbook(o)
 		}
@@ -58,18 +103,58 @@ func (t *Trader) infof(format string, v ...interface{}) {
 	t.Logger.Printf(format, v...)
 }
 
-func (t *Trader) logLatency(ob tc.SFOXOrderbook) {
-	totalTime := time.Now().Sub(ob.SFOXTimestamp)
-	internalLatency := time.Now().Sub(ob.ReceiptTimestamp)
-	networkLatency := ob.ReceiptTimestamp.Sub(ob.SFOXTimestamp)
-	t.infof("LATENCY internal: %s network: %s total: %s", internalLatency.String(), networkLatency.String(), totalTime.String())
+// TradeLimits returns a snapshot of the limits currently in effect
+func (t *Trader) TradeLimits() TradeLimits {
+	t.limitsMtx.RLock()
+	defer t.limitsMtx.RUnlock()
+	return t.Config.TradeLimits
+}
+
+// SetTradeLimits swaps the limits used for new arbs. An arb that is already in flight isn't affected, it trades the prices and quantity it was found with.
+func (t *Trader) SetTradeLimits(limits TradeLimits) {
+	t.limitsMtx.Lock()
+	defer t.limitsMtx.Unlock()
+	t.Config.TradeLimits = limits
+}
+
+// VenueRules returns the venue rules currently in effect
+func (t *Trader) VenueRules() VenueRules {
+	t.limitsMtx.RLock()
+	defer t.limitsMtx.RUnlock()
+	return t.Config.Venues
+}
+
+func (t *Trader) SetVenueRules(rules VenueRules) {
+	t.limitsMtx.Lock()
+	defer t.limitsMtx.Unlock()
+	t.Config.Venues = rules
 }
 
-func (t *Trader) handleOrderbook(o tc.SFOXOrderbook) {
+func (t *Trader) handleOrderbook(o sfoxBook) {
+	t.manager.latency.Observe(o, time.Now())
+	if rules := t.VenueRules(); !rules.IsZero() {
+		if !o.venuesKnown() {
+			t.unknownVenueBooks++
+			if time.Since(t.unknownVenueLogged) > time.Minute {
+				t.infof("skipped %d book(s) since startup without a venue for each offer, the venue rules can't be applied to them", t.unknownVenueBooks)
+				t.unknownVenueLogged = time.Now()
+			}
+			return
+		}
+		o = rules.Apply(o)
+	}
 	quoteBalance := t.getBalance(t.Config.Pair.Quote)
-	arb, err := FindArb(o, t.Config.TradeLimits, quoteBalance)
+	arb, err := FindArb(o.SFOXOrderbook, t.TradeLimits(), quoteBalance)
 	// t.infof(o.DescribeArb(t.Config.FeeRateBps))
 	if err == nil {
+		if t.Config.MonitorOnly {
+			t.infof("monitoring only, not entering arb: expected profit %s (%s bps)", t.class.FormatAmount(arb.ProfitGoal), arb.ProfitGoalBps.StringFixed(2))
+			return
+		}
+		if status := t.manager.FeedStatus(); status != FeedLive {
+			t.infof("not entering arb, the feed is %s", status)
+			return
+		}
 		// non-blocking send, trader might already be trading
 		select {
 		case t.arbChan <- arb:
@@ -87,11 +172,21 @@ func (t *Trader) handleOrderbook(o tc.SFOXOrderbook) {
 
 func (t *Trader) trade() {
 	go func() {
-		subProcessKillChan := make(chan struct{})
+		defer close(t.done)
 		for {
 			// blocking receive
-			arb := <-t.arbChan
+			var arb arbStrat
+			select {
+			case arb = <-t.arbChan:
+			case <-t.killChan:
+				t.infof("stopped")
+				return
+			}
+			// closed to stop this arb's status loops, they also stop on their own once the trader's stopped
+			subProcessKillChan := make(chan struct{})
+			var stopStatusLoops sync.Once
 			t.infof("entering arb: %+v", arb)
+			t.infof("expected profit: %s (%s bps)", t.class.FormatAmount(arb.ProfitGoal), arb.ProfitGoalBps.StringFixed(2))
 			t.errCount = 0
 			var buyOrderStatus sfoxapi.OrderStatusResponse
 			var sellOrderStatus sfoxapi.OrderStatusResponse
@@ -100,24 +195,18 @@ func (t *Trader) trade() {
 				select {
 				case <-t.killChan:
 					// exit the position
-					if arb.Status == STATUS_BUY_STARTED {
-						t.cancelOrder(buyOrderStatus.ID)
-						subProcessKillChan <- struct{}{}
-					} else if arb.Status == STATUS_SELL_STARTED {
-						t.cancelOrder(sellOrderStatus.ID)
-						subProcessKillChan <- struct{}{}
-					}
+					t.unwind(arb, buyOrderStatus, sellOrderStatus)
 					return
 				case <-t.noArbChan:
 					// exit the position
 					if arb.Status == STATUS_BUY_STARTED {
 						t.cancelOrder(buyOrderStatus.ID)
-						subProcessKillChan <- struct{}{}
+						stopStatusLoops.Do(func() { close(subProcessKillChan) })
 					}
 					// leave the sell order open to attempt to exit the position still....
 					break
 				case buyOrderStatus = <-t.buyOrderStatusChan:
-					fmt.Println("update from buy order status channel")
+					t.infof("update from buy order status channel")
 					// update fill information if anything has changed
 					if buyOrderStatus.FilledQuantity.Equal(arb.Quantity) {
 						// complete fill:
@@ -165,6 +254,7 @@ func (t *Trader) trade() {
 						t.infof("buy started")
 						arb.Status = STATUS_BUY_STARTED
 						arb.BuyTime = time.Now()
+						buyOrderStatus = status
 						t.startOrderStatusLoop(status.ID, t.buyOrderStatusChan, subProcessKillChan)
 					} else {
 						t.infof("unrecognized status: %s", statusLower)
@@ -186,6 +276,7 @@ func (t *Trader) trade() {
 						t.infof("sell started")
 						arb.Status = STATUS_SELL_STARTED
 						arb.BuyTime = time.Now()
+						sellOrderStatus = status
 						t.startOrderStatusLoop(status.ID, t.sellOrderStatusChan, subProcessKillChan)
 					} else {
 						t.infof("order %v requires manual intervention - returned status %v", status.ID, statusLower)
@@ -193,7 +284,7 @@ func (t *Trader) trade() {
 
 				}
 				if arb.Status == STATUS_SELL_COMPLETE {
-					t.infof("ARB COMPLETE. PROFIT: %s%s", buyOrderStatus.NetProceeds.Add(sellOrderStatus.NetProceeds).String(), string(t.Config.Pair.Quote))
+					t.infof("ARB COMPLETE. PROFIT: %s", t.class.FormatAmount(buyOrderStatus.NetProceeds.Add(sellOrderStatus.NetProceeds)))
 					break
 				}
 				if arb.Status == STATUS_BUY_STARTED && time.Now().Sub(arb.BuyTime).Seconds() > 8.0 {
@@ -202,6 +293,7 @@ func (t *Trader) trade() {
 					break
 				}
 			}
+			stopStatusLoops.Do(func() { close(subProcessKillChan) })
 		}
 	}()
 }
@@ -214,6 +306,9 @@ func (t *Trader) startOrderStatusLoop(orderID int64, statusChannel chan sfoxapi.
 			select {
 			case <-killChan:
 				return
+			case <-t.killChan:
+				// unwind follows the orders itself
+				return
 			default:
 			}
 			time.Sleep(time.Millisecond * 500)
@@ -225,11 +320,13 @@ func (t *Trader) startOrderStatusLoop(orderID int64, statusChannel chan sfoxapi.
 				return
 			}
 			if newOrderStatus.Status == "Done" {
-				statusChannel <- newOrderStatus //notify the loop that there was an order status update
+				t.sendOrderStatus(newOrderStatus, statusChannel, killChan) //notify the loop that there was an order status update
 				return
 			}
 			if newOrderStatus.FilledQuantity.GreaterThan(lastOrderStatus.FilledQuantity) {
-				statusChannel <- newOrderStatus //notify the loop that there was an order status update
+				if !t.sendOrderStatus(newOrderStatus, statusChannel, killChan) { //notify the loop that there was an order status update
+					return
+				}
 				continue
 			}
 			lastOrderStatus = newOrderStatus
@@ -237,24 +334,105 @@ func (t *Trader) startOrderStatusLoop(orderID int64, statusChannel chan sfoxapi.
 	}()
 }
 
+// sendOrderStatus hands status to the trade loop, false if the loop stopped listening first
+func (t *Trader) sendOrderStatus(status sfoxapi.OrderStatusResponse, statusChannel chan sfoxapi.OrderStatusResponse, killChan chan struct{}) bool {
+	select {
+	case statusChannel <- status:
+		return true
+	case <-killChan:
+	case <-t.killChan:
+	}
+	return false
+}
+
+// unwind exits arb's position once the trader's been stopped. An open buy is canceled, whatever was
+// bought is sold, and it waits up to unwindTimeout for the sell to be done.
+func (t *Trader) unwind(arb arbStrat, buyOrderStatus, sellOrderStatus sfoxapi.OrderStatusResponse) {
+	if arb.Status == STATUS_BUY_STARTED {
+		t.cancelOrder(buyOrderStatus.ID)
+		// the fill may have moved on since the last update
+		if status, err := t.getOrderStatus(buyOrderStatus.ID); err == nil {
+			buyOrderStatus = status
+		}
+		if !buyOrderStatus.FilledQuantity.IsPositive() {
+			t.infof("stopped, the buy was canceled before it filled")
+			return
+		}
+		arb.Status = STATUS_BUY_COMPLETE
+	}
+	if arb.Status == STATUS_BUY_COMPLETE {
+		t.infof("stopping, selling the %s bought for the arb", buyOrderStatus.FilledQuantity)
+		sellOrder := NewSellOrderFromArbStrat(arb, buyOrderStatus.FilledQuantity)
+		for errCount := 0; arb.Status == STATUS_BUY_COMPLETE; errCount++ {
+			if errCount > 5 {
+				t.infof("too many errors - %s bought for the arb needs to be sold manually", buyOrderStatus.FilledQuantity)
+				return
+			}
+			status, err := t.executeOrder(*sellOrder)
+			if err != nil {
+				t.infof("error attempting to sell %s", err.Error())
+				time.Sleep(time.Second)
+				continue
+			}
+			if strings.ToLower(status.Status) != "started" {
+				t.infof("order %v requires manual intervention - returned status %v", status.ID, strings.ToLower(status.Status))
+				return
+			}
+			sellOrderStatus = status
+			arb.Status = STATUS_SELL_STARTED
+		}
+	}
+	if arb.Status == STATUS_SELL_STARTED {
+		t.infof("stopping, waiting up to %s for sell %v to be done", t.unwindTimeout, sellOrderStatus.ID)
+		deadline := time.Now().Add(t.unwindTimeout)
+		for {
+			status, err := t.getOrderStatus(sellOrderStatus.ID)
+			if err == nil {
+				sellOrderStatus = status
+				if status.Status == "Done" || status.Status == "Canceled" {
+					t.infof("stopped, sell %v is %s having sold %s", status.ID, status.Status, status.FilledQuantity)
+					return
+				}
+			}
+			if time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(time.Millisecond * 500)
+		}
+		t.cancelOrder(sellOrderStatus.ID)
+		if status, err := t.getOrderStatus(sellOrderStatus.ID); err == nil {
+			sellOrderStatus = status
+		}
+		t.infof("ERROR stopped with sell %v canceled after %s, %s of the %s bought for the arb needs to be sold manually",
+			sellOrderStatus.ID, t.unwindTimeout, buyOrderStatus.FilledQuantity.Sub(sellOrderStatus.FilledQuantity), buyOrderStatus.FilledQuantity)
+		return
+	}
+	t.infof("stopped")
+}
+
 func (t *Trader) executeOrder(orderParams TraderOrder) (orderStatus sfoxapi.OrderStatusResponse, err error) {
-	client := t.manager.GetSFOXClient()
-	orderStatus, err = client.NewOrder(orderParams.Quantity, orderParams.LimitPrice, orderParams.AlgoID, orderParams.Pair.String(), string(orderParams.Side))
-	t.manager.ReturnSFOXClient(client)
+	err = t.manager.withVenue(EndpointOrder, func(venue orderVenue) (err error) {
+		orderStatus, err = venue.NewOrder(orderParams.Quantity, orderParams.LimitPrice, orderParams.AlgoID, orderParams.Pair.String(), string(orderParams.Side))
+		return
+	})
 	return
 }
 
 func (t *Trader) getOrderStatus(id int64) (orderStatus sfoxapi.OrderStatusResponse, err error) {
-	client := t.manager.GetSFOXClient()
-	orderStatus, err = client.OrderStatus(id)
-	t.manager.ReturnSFOXClient(client)
+	err = t.manager.withVenue(EndpointStatus, func(venue orderVenue) (err error) {
+		orderStatus, err = venue.OrderStatus(id)
+		return
+	})
 	return
 }
 
 func (t *Trader) cancelOrder(id int64) (err error) {
-	client := t.manager.GetSFOXClient()
-	err = client.CancelOrder(id)
-	t.manager.ReturnSFOXClient(client)
+	err = t.manager.withVenue(EndpointCancel, func(venue orderVenue) error {
+		return venue.CancelOrder(id)
+	})
+	if err != nil {
+		t.infof("error canceling order %v: %s", id, err.Error())
+	}
 	return
 }
 
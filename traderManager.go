@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 
 	sfox "github.com/ldcicconi/sfox-api-lib"
@@ -24,22 +28,50 @@ func NewSafeBalanceMap() *SafeBalanceMap {
 }
 
 type traderManager struct {
-	Logger         *log.Logger
-	SFOXClientPool *SFOXAPIClientPool
-	balances       *SafeBalanceMap
-	traders        map[tc.Pair]*Trader // one trader per pair
+	Logger              *log.Logger
+	SFOXReadClientPool  *SFOXAPIClientPool // balance and order status polling
+	SFOXTradeClientPool *SFOXAPIClientPool // order placement and cancels
+	checkoutTimeout     time.Duration
+	rateLimiter         *rateLimiter // shared by both pools, keys are limited individually
+	balances            *SafeBalanceMap
+	simulatedVenue      *simulatedVenue     // when set, orders and balances go here instead of SFOX
+	liveOrders          bool                // orders can only go to SFOX when the market data is the live feed
+	feedStatus          func() feedStatus   // the status of the market data source, nil counts as live
+	unknownPairs        *unknownPairs       // books for pairs without a trader
+	latency             *latencyStats       // fed by the traders as they pick up books
+	traders             map[tc.Pair]*Trader // one trader per pair, pairs come and go with config reloads
+	tradersMtx          sync.RWMutex
+	started             bool                  // traders added once the manager is started are started straight away
+	removed             map[tc.Pair]time.Time // when each pair's trader was removed, so books still in flight don't get it a monitor
 }
 
-func NewTraderManager(logger *log.Logger, sfoxAPIKeys []string, traderConfigs []TraderConfig) *traderManager {
+// books for a pair that was just removed can still be on their way, they don't start a monitoring only trader for this long
+const removedPairGrace = time.Minute
+
+func NewTraderManager(logger *log.Logger, sfoxAPIKeys []APIKey, traderConfigs []TraderConfig, poolConfigs ClientPoolsConfig, rateLimits RateLimitsConfig) *traderManager {
 	traders := make(map[tc.Pair]*Trader)
 	for _, tc := range traderConfigs {
 		traders[tc.Pair] = NewTrader(tc, logger, nil)
 	}
+	unlabelled := 0
+	for _, k := range sfoxAPIKeys {
+		if len(k.Roles) > 1 {
+			unlabelled++
+		}
+	}
+	if unlabelled > 0 {
+		logger.Printf("[traderManager] [info] %d API key(s) have no role label and will be used for both reads and trading", unlabelled)
+	}
 	return &traderManager{
-		Logger:         logger,
-		balances:       NewSafeBalanceMap(),
-		SFOXClientPool: NewSFOXAPIClientPool(sfoxAPIKeys, len(traders)+2),
-		traders:        traders,
+		Logger:              logger,
+		balances:            NewSafeBalanceMap(),
+		SFOXReadClientPool:  NewSFOXAPIClientPool(string(KeyRoleRead), keysForRole(sfoxAPIKeys, KeyRoleRead), len(traders)+2, poolConfigs.Read, logger),
+		SFOXTradeClientPool: NewSFOXAPIClientPool(string(KeyRoleTrade), keysForRole(sfoxAPIKeys, KeyRoleTrade), len(traders)+2, poolConfigs.Trade, logger),
+		checkoutTimeout:     poolConfigs.CheckoutTimeout.Duration,
+		rateLimiter:         NewRateLimiter(rateLimits),
+		unknownPairs:        NewUnknownPairs(DefaultUnknownPairsConfig()),
+		latency:             NewLatencyStats(DefaultLatencyConfig()),
+		traders:             traders,
 	}
 }
 
@@ -47,34 +79,103 @@ func (t *traderManager) LogInfo(text string) {
 	t.Logger.Println("[traderManager] [info] " + text)
 }
 
-func (t *traderManager) Start(orderbookChan chan tc.SFOXOrderbook) {
+func (t *traderManager) Start(orderbookChan chan sfoxBook) {
 	t.initTraders()
 	t.monitorBalances()
+	t.monitorClientPools()
+	t.monitorBookSlots()
+	t.monitorLatency()
 	time.Sleep(2 * time.Second)
 	t.startTraders()
 	t.routeOrderbooks(orderbookChan)
 }
 
 func (tm *traderManager) initTraders() {
+	tm.tradersMtx.Lock()
+	defer tm.tradersMtx.Unlock()
 	for _, t := range tm.traders {
 		t.manager = tm
 	}
 }
 
 func (t *traderManager) startTraders() {
+	t.tradersMtx.Lock()
+	defer t.tradersMtx.Unlock()
+	t.started = true
 	for _, trader := range t.traders {
 		trader.Start()
 	}
 }
 
-func (t *traderManager) routeOrderbooks(orderbookChan chan tc.SFOXOrderbook) {
+// trader returns the trader for pair, if there is one
+func (t *traderManager) trader(pair tc.Pair) (*Trader, bool) {
+	t.tradersMtx.RLock()
+	defer t.tradersMtx.RUnlock()
+	trader, ok := t.traders[pair]
+	return trader, ok
+}
+
+// Pairs returns the configured pairs, the ones with a trader that isn't only monitoring
+func (t *traderManager) Pairs() (pairs []tc.Pair) {
+	t.tradersMtx.RLock()
+	defer t.tradersMtx.RUnlock()
+	for pair, trader := range t.traders {
+		if !trader.Config.MonitorOnly {
+			pairs = append(pairs, pair)
+		}
+	}
+	return pairs
+}
+
+func (t *traderManager) routeOrderbooks(orderbookChan chan sfoxBook) {
 	go func() {
 		for o := range orderbookChan {
-			t.traders[o.Pair].OrderbookChan <- o
+			t.routeOrderbook(o)
 		}
 	}()
 }
 
+// routeOrderbook hands o to its pair's trader. A panic is logged and the book dropped, so one bad
+// message can't take down the router and every trader with it.
+func (t *traderManager) routeOrderbook(o sfoxBook) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.LogInfo(fmt.Sprintf("ERROR routing a %s book, dropped it: %v", o.Pair, r))
+		}
+	}()
+	if t.simulatedVenue != nil {
+		t.simulatedVenue.UpdateBook(o)
+	}
+	trader, ok := t.trader(o.Pair)
+	if !ok {
+		// the feed can send pairs we never configured, and a pair that was just removed can still have books on the way
+		if trader, ok = t.unknownPair(o.Pair); !ok {
+			return
+		}
+	}
+	trader.Books.Put(o)
+}
+
+// unknownPair counts a book for a pair without a trader, and starts a monitoring only trader for it if that's configured
+func (t *traderManager) unknownPair(pair tc.Pair) (*Trader, bool) {
+	count, log := t.unknownPairs.seen(pair, time.Now())
+	if log {
+		t.LogInfo(fmt.Sprintf("got %d book(s) since startup for %s, which has no trader", count, pair))
+	}
+	if !t.unknownPairs.config.Monitor {
+		return nil, false
+	}
+	config, err := t.unknownPairs.config.monitorConfig(pair)
+	if err != nil {
+		if log {
+			t.LogInfo(fmt.Sprintf("can't monitor %s: %s", pair, err.Error()))
+		}
+		return nil, false
+	}
+	t.AddTrader(config)
+	return t.trader(pair)
+}
+
 func (t *traderManager) monitorBalances() {
 	// Poll SFOX every 5 seconds and update local register
 	go func() {
@@ -84,23 +185,81 @@ func (t *traderManager) monitorBalances() {
 	}()
 }
 
+func (t *traderManager) monitorClientPools() {
+	go func() {
+		for range time.Tick(time.Minute) {
+			for _, role := range keyRoles {
+				stats := t.clientPool(role).Stats()
+				t.LogInfo(fmt.Sprintf("%s client pool: size %d, checked out %d, peak checked out %d, quarantined %d", role, stats.Size, stats.CheckedOut, stats.PeakCheckedOut, stats.Quarantined))
+			}
+			t.LogInfo(fmt.Sprintf("rate limited by SFOX %d time(s) since startup", t.rateLimiter.RateLimited()))
+		}
+	}()
+}
+
+// monitorBookSlots logs how many books each trader has skipped because a newer one arrived first
+func (t *traderManager) monitorBookSlots() {
+	go func() {
+		for range time.Tick(time.Minute) {
+			t.tradersMtx.RLock()
+			for pair, trader := range t.traders {
+				if dropped := trader.Books.Dropped(); dropped > 0 {
+					t.LogInfo(fmt.Sprintf("%s trader skipped %d stale book(s) since startup", pair, dropped))
+				}
+			}
+			t.tradersMtx.RUnlock()
+		}
+	}()
+}
+
+// monitorLatency logs each pair's latency summary every reportInterval, and straight away on SIGUSR2
+func (t *traderManager) monitorLatency() {
+	requests := make(chan os.Signal, 1)
+	signal.Notify(requests, syscall.SIGUSR2)
+	go func() {
+		ticker := time.NewTicker(t.latency.config.ReportInterval.Duration)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-requests:
+			}
+			for _, line := range t.latency.report() {
+				t.LogInfo(line)
+			}
+		}
+	}()
+}
+
+// Latency returns pair's rolling latency summaries, false if it hasn't had a book yet
+func (t *traderManager) Latency(pair tc.Pair) (PairLatency, bool) {
+	return t.latency.Pair(pair)
+}
+
 func (t *traderManager) checkAndUpdateBalances() {
 	// t.Logger.Println("checking balance")
-	client, err := t.SFOXClientPool.GetAPIClient()
-	defer t.SFOXClientPool.ReturnAPIClient(client)
-	if err != nil {
-		t.Logger.Printf("error getting an SFOX client %s", err.Error())
+	if t.simulatedVenue != nil {
+		balances := t.simulatedVenue.Balances()
+		t.balances.mtx.Lock()
+		t.balances.m = balances
+		t.balances.mtx.Unlock()
+		return
 	}
-	balances, err := client.GetBalances()
+	err := t.withSFOXClient(EndpointBalance, func(client *sfoxapi.SFOXAPI) error {
+		balances, err := client.GetBalances()
+		if err != nil {
+			return err
+		}
+		t.balances.mtx.Lock()
+		for _, b := range balances {
+			t.balances.m[tc.Currency(b.Currency)] = b.Available
+		}
+		t.balances.mtx.Unlock()
+		return nil
+	})
 	if err != nil {
 		t.Logger.Printf("error getting balances %s", err.Error())
-		return
 	}
-	t.balances.mtx.Lock()
-	for _, b := range balances {
-		t.balances.m[tc.Currency(b.Currency)] = b.Available
-	}
-	t.balances.mtx.Unlock()
 }
 
 func (t *traderManager) logArb(o tc.SFOXOrderbook) {
@@ -115,22 +274,205 @@ func (t *traderManager) logArb(o tc.SFOXOrderbook) {
 	t.LogInfo(fmt.Sprintf("%s %s: %s (%s bps)", o.Pair, condition, arb, arbBps))
 }
 
-func (tm *traderManager) GetSFOXClient() *sfox.SFOXAPI {
-	// this is used by the traders, so I'm going to make this block until a client is available
-	var c *sfoxapi.SFOXAPI
-	var err error
-	for true {
-		c, err = tm.SFOXClientPool.GetAPIClient()
-		if err != nil {
+// UpdateTraderConfigs applies a reloaded config to the traders. Pairs that are new get a trader, pairs that
+// are gone have theirs stopped, and the rest get the reloaded limits and venue rules. It returns whether the
+// set of pairs changed, in which case the market data needs resubscribing.
+func (tm *traderManager) UpdateTraderConfigs(configs []TraderConfig) (pairsChanged bool) {
+	wanted := make(map[tc.Pair]bool)
+	for _, config := range configs {
+		wanted[config.Pair] = true
+	}
+	// removed traders unwind side by side, so the reload waits for the slowest sell rather than all of them
+	var removing sync.WaitGroup
+	for _, pair := range tm.Pairs() {
+		if !wanted[pair] {
+			removing.Add(1)
+			go func(pair tc.Pair) {
+				defer removing.Done()
+				tm.RemoveTrader(pair)
+			}(pair)
+			pairsChanged = true
+		}
+	}
+	removing.Wait()
+	for _, config := range configs {
+		trader, ok := tm.trader(config.Pair)
+		if !ok || trader.Config.MonitorOnly {
+			// AddTrader replaces a monitor with a real trader if the pair turned up on the feed before it was configured
+			tm.AddTrader(config)
+			pairsChanged = true
 			continue
 		}
-		break
+		if !trader.TradeLimits().Equal(config.TradeLimits) {
+			trader.SetTradeLimits(config.TradeLimits)
+			tm.LogInfo(fmt.Sprintf("updated %s limits to %+v", config.Pair, config.TradeLimits))
+		}
+		if !trader.VenueRules().Equal(config.Venues) {
+			trader.SetVenueRules(config.Venues)
+			tm.LogInfo(fmt.Sprintf("updated %s venue rules to %+v", config.Pair, config.Venues))
+		}
 	}
-	return c
+	return pairsChanged
 }
 
-func (tm *traderManager) ReturnSFOXClient(c *sfox.SFOXAPI) {
-	tm.SFOXClientPool.ReturnAPIClient(c)
+// AddTrader creates a trader for config.Pair, and starts it if the manager is already running. A real
+// trader replaces a monitoring only one for the pair. A monitoring only trader isn't added for a pair
+// that was removed less than removedPairGrace ago.
+func (tm *traderManager) AddTrader(config TraderConfig) {
+	tm.tradersMtx.Lock()
+	existing, ok := tm.traders[config.Pair]
+	if ok && (config.MonitorOnly || !existing.Config.MonitorOnly) {
+		tm.tradersMtx.Unlock()
+		return
+	}
+	if config.MonitorOnly && time.Since(tm.removed[config.Pair]) < removedPairGrace {
+		tm.tradersMtx.Unlock()
+		return
+	}
+	if !config.MonitorOnly {
+		delete(tm.removed, config.Pair)
+	}
+	trader := NewTrader(config, tm.Logger, tm)
+	tm.traders[config.Pair] = trader
+	if tm.started {
+		trader.Start()
+	}
+	tm.tradersMtx.Unlock()
+	if ok {
+		// a monitor never has an arb open, so this doesn't wait
+		existing.Stop()
+		tm.LogInfo(fmt.Sprintf("replaced the monitoring only trader for %s with a trader", config.Pair))
+		return
+	}
+	tm.resizeClientPools()
+	if config.MonitorOnly {
+		tm.LogInfo(fmt.Sprintf("added a monitoring only trader for %s", config.Pair))
+	} else {
+		tm.LogInfo(fmt.Sprintf("added a trader for %s", config.Pair))
+	}
+}
+
+// RemoveTrader stops routing books to the trader for pair and stops it. Like Stop, it returns once the
+// trader's open arb has been unwound, which can mean waiting up to defaultUnwindTimeout for its exit sell.
+func (tm *traderManager) RemoveTrader(pair tc.Pair) {
+	tm.tradersMtx.Lock()
+	trader, ok := tm.traders[pair]
+	delete(tm.traders, pair)
+	if ok {
+		if tm.removed == nil {
+			tm.removed = make(map[tc.Pair]time.Time)
+		}
+		tm.removed[pair] = time.Now()
+	}
+	tm.tradersMtx.Unlock()
+	if !ok {
+		return
+	}
+	trader.Stop()
+	tm.resizeClientPools()
+	tm.LogInfo(fmt.Sprintf("removed the trader for %s", pair))
+}
+
+// resizeClientPools sizes the client pools for the number of traders, the same way NewTraderManager does
+func (tm *traderManager) resizeClientPools() {
+	tm.tradersMtx.RLock()
+	size := len(tm.traders) + 2
+	tm.tradersMtx.RUnlock()
+	for _, role := range keyRoles {
+		if pool := tm.clientPool(role); pool != nil {
+			if added, retired := pool.Resize(size); added+retired > 0 {
+				tm.LogInfo(fmt.Sprintf("resized the %s client pool for %d traders: %d client(s) added, %d retired", role, size-2, added, retired))
+			}
+		}
+	}
+}
+
+func (tm *traderManager) clientPool(role keyRole) *SFOXAPIClientPool {
+	if role == KeyRoleTrade {
+		return tm.SFOXTradeClientPool
+	}
+	return tm.SFOXReadClientPool
+}
+
+// GetSFOXClient checks out a client whose key is allowed to be used for role,
+// blocking until one is available or ctx is done
+func (tm *traderManager) GetSFOXClient(ctx context.Context, role keyRole) (*sfox.SFOXAPI, error) {
+	return tm.clientPool(role).GetAPIClientContext(ctx)
+}
+
+func (tm *traderManager) ReturnSFOXClient(role keyRole, c *sfox.SFOXAPI) {
+	tm.clientPool(role).ReturnAPIClient(c)
+}
+
+// withSFOXClient checks out a client that can make calls of class, once the rate limiter lets a call through
+// on its key, runs call with it and returns it to the pool. A client whose key is throttled goes back to the
+// pool while the caller waits, so throttled callers don't starve everyone else of clients. Checkout and rate
+// limiting together wait at most checkoutTimeout.
+func (tm *traderManager) withSFOXClient(class endpointClass, call func(*sfoxapi.SFOXAPI) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), tm.checkoutTimeout)
+	defer cancel()
+	// polling is held back only until this call has its token, not for the request itself
+	waiting := tm.rateLimiter.Waiting(class)
+	defer waiting()
+	role := class.role()
+	var client *sfoxapi.SFOXAPI
+	var key string
+	for {
+		var err error
+		client, err = tm.GetSFOXClient(ctx, role)
+		if err != nil {
+			return fmt.Errorf("getting an SFOX %s client: %s", role, err.Error())
+		}
+		key = tm.clientPool(role).KeyOf(client)
+		wait := tm.rateLimiter.TryReserve(key, class)
+		if wait == 0 {
+			waiting()
+			break
+		}
+		tm.ReturnSFOXClient(role, client)
+		if err = sleep(ctx, wait); err != nil {
+			return fmt.Errorf("waiting for the %s rate limit: %s", class, err.Error())
+		}
+	}
+	defer tm.ReturnSFOXClient(role, client)
+	err := call(client)
+	tm.rateLimiter.Observe(key, err)
+	tm.clientPool(role).RecordResult(client, err)
+	return err
+}
+
+var ErrNoLiveOrders = fmt.Errorf("not sending orders to SFOX, the market data isn't the live feed")
+
+// withVenue runs call against the simulated venue if there is one, otherwise against SFOX through withSFOXClient.
+// Without live market data nothing is sent to SFOX, whether or not there's a simulated venue.
+func (tm *traderManager) withVenue(class endpointClass, call func(orderVenue) error) error {
+	if tm.simulatedVenue != nil {
+		return call(tm.simulatedVenue)
+	}
+	if !tm.liveOrders {
+		return ErrNoLiveOrders
+	}
+	return tm.withSFOXClient(class, func(client *sfoxapi.SFOXAPI) error {
+		return call(client)
+	})
+}
+
+// UpdateAPIKeys moves both client pools onto a new set of keys, split by role
+func (tm *traderManager) UpdateAPIKeys(keys []APIKey) (added, retired int) {
+	for _, role := range keyRoles {
+		a, r := tm.clientPool(role).UpdateAPIKeys(keysForRole(keys, role))
+		added += a
+		retired += r
+	}
+	return added, retired
+}
+
+// FeedStatus is the status of the market data the traders are getting
+func (tm *traderManager) FeedStatus() feedStatus {
+	if tm.feedStatus == nil {
+		return FeedLive
+	}
+	return tm.feedStatus()
 }
 
 func (tm *traderManager) GetBalance(c tc.Currency) (balance decimal.Decimal) {
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	tc "github.com/ldcicconi/trading-common"
+	"github.com/shopspring/decimal"
+)
+
+func TestUpdateTraderConfigsAddsAndRemovesPairs(t *testing.T) {
+	btcusd, ethusd := *tc.NewPair("btcusd"), *tc.NewPair("ethusd")
+	unknown := NewUnknownPairs(UnknownPairsConfig{LogInterval: Duration{time.Hour}})
+	tm := &traderManager{Logger: testLogger, balances: NewSafeBalanceMap(), traders: make(map[tc.Pair]*Trader), unknownPairs: unknown}
+	tm.AddTrader(TraderConfig{Pair: btcusd})
+	btcTrader, _ := tm.trader(btcusd)
+
+	if !tm.UpdateTraderConfigs([]TraderConfig{{Pair: ethusd}}) {
+		t.Fatal("expected the pairs to have changed")
+	}
+	if pairs := tm.Pairs(); len(pairs) != 1 || pairs[0] != ethusd {
+		t.Fatalf("expected only ethusd to have a trader, got %v", pairs)
+	}
+	select {
+	case <-btcTrader.killChan:
+	case <-time.After(time.Second):
+		t.Fatal("expected the btcusd trader to be stopped")
+	}
+	if tm.UpdateTraderConfigs([]TraderConfig{{Pair: ethusd}}) {
+		t.Error("expected no change in pairs for the same config")
+	}
+
+	// books for the removed pair are dropped instead of routed
+	tm.routeOrderbook(sfoxBook{SFOXOrderbook: tc.SFOXOrderbook{Pair: btcusd}})
+	tm.routeOrderbook(sfoxBook{SFOXOrderbook: tc.SFOXOrderbook{Pair: ethusd}})
+	if _, ok := btcTrader.Books.Take(); ok {
+		t.Error("expected the removed btcusd trader not to get the btcusd book")
+	}
+	if n := unknown.Counts()[btcusd]; n != 1 {
+		t.Errorf("expected the btcusd book to be counted as having no trader, got %d", n)
+	}
+	ethTrader, _ := tm.trader(ethusd)
+	if book, ok := ethTrader.Books.Take(); !ok || book.Pair != ethusd {
+		t.Error("expected the ethusd book to be routed to the ethusd trader")
+	}
+}
+
+// fakeMarketData records the pairs it's set to
+type fakeMarketData struct {
+	pairs [][]tc.Pair
+}
+
+func (f *fakeMarketData) Start(orderbookChan chan sfoxBook) {}
+func (f *fakeMarketData) Status() feedStatus                { return FeedLive }
+func (f *fakeMarketData) SetPairs(pairs []tc.Pair)          { f.pairs = append(f.pairs, pairs) }
+
+func TestApplyConfigResubscribesWhenThePairsChange(t *testing.T) {
+	btcusd, ethusd := *tc.NewPair("btcusd"), *tc.NewPair("ethusd")
+	md := &fakeMarketData{}
+	tm := &traderManager{Logger: testLogger, balances: NewSafeBalanceMap(), traders: make(map[tc.Pair]*Trader)}
+	tm.AddTrader(TraderConfig{Pair: btcusd})
+	a := &app{logger: testLogger, md: md, tm: tm}
+
+	a.ApplyConfig(&Config{Traders: []TraderConfig{{Pair: btcusd, TradeLimits: TradeLimits{ProfitThresholdBps: decimal.New(5, 0)}}}})
+	if len(md.pairs) != 0 {
+		t.Errorf("expected no resubscribe when only the limits change, got %v", md.pairs)
+	}
+	a.ApplyConfig(&Config{Traders: []TraderConfig{{Pair: ethusd}}})
+	if len(md.pairs) != 1 || len(md.pairs[0]) != 1 || md.pairs[0][0] != ethusd {
+		t.Errorf("expected the market data to be set to ethusd, got %v", md.pairs)
+	}
+}
+
+func TestRouteOrderbookHandlesUnknownPairs(t *testing.T) {
+	btcusd, ethusd, ltcusd := *tc.NewPair("btcusd"), *tc.NewPair("ethusd"), *tc.NewPair("ltcusd")
+	unknown := UnknownPairsConfig{LogInterval: Duration{time.Hour}}
+	tm := &traderManager{Logger: testLogger, balances: NewSafeBalanceMap(), traders: make(map[tc.Pair]*Trader), unknownPairs: NewUnknownPairs(unknown)}
+	tm.AddTrader(TraderConfig{Pair: btcusd})
+
+	for i := 0; i < 3; i++ {
+		tm.routeOrderbook(sfoxBook{SFOXOrderbook: tc.SFOXOrderbook{Pair: ethusd}})
+	}
+	if n := tm.unknownPairs.Counts()[ethusd]; n != 3 {
+		t.Errorf("expected 3 books counted for ethusd, got %d", n)
+	}
+	if _, ok := tm.trader(ethusd); ok {
+		t.Error("expected no trader for ethusd with monitoring off")
+	}
+
+	// a book that panics is dropped, the next one still gets routed
+	tm.traders[ltcusd] = &Trader{}
+	tm.routeOrderbook(sfoxBook{SFOXOrderbook: tc.SFOXOrderbook{Pair: ltcusd}})
+	delete(tm.traders, ltcusd)
+	tm.routeOrderbook(sfoxBook{SFOXOrderbook: tc.SFOXOrderbook{Pair: btcusd}})
+	trader, _ := tm.trader(btcusd)
+	if _, ok := trader.Books.Take(); !ok {
+		t.Error("expected the btcusd book to be routed after the panic")
+	}
+
+	unknown.Monitor = true
+	unknown.limits = map[string]TradeLimits{"usd": {}}
+	tm.unknownPairs = NewUnknownPairs(unknown)
+	tm.routeOrderbook(sfoxBook{SFOXOrderbook: tc.SFOXOrderbook{Pair: ethusd}})
+	monitor, ok := tm.trader(ethusd)
+	if !ok || !monitor.Config.MonitorOnly {
+		t.Fatal("expected a monitoring only trader for ethusd")
+	}
+	for _, pair := range tm.Pairs() {
+		if pair == ethusd {
+			t.Error("expected the monitored pair to be left out of the configured pairs")
+		}
+	}
+	if !tm.UpdateTraderConfigs([]TraderConfig{{Pair: btcusd}, {Pair: ethusd}}) {
+		t.Fatal("expected configuring ethusd to change the pairs")
+	}
+	if trader, _ := tm.trader(ethusd); trader.Config.MonitorOnly {
+		t.Error("expected the monitor to be replaced by a real trader once ethusd is configured")
+	}
+}
+
+func TestRemovedPairsDontGetAMonitor(t *testing.T) {
+	btcusd := *tc.NewPair("btcusd")
+	unknown := UnknownPairsConfig{Monitor: true, LogInterval: Duration{time.Hour}, limits: map[string]TradeLimits{"usd": {}}}
+	tm := &traderManager{Logger: testLogger, balances: NewSafeBalanceMap(), traders: make(map[tc.Pair]*Trader), unknownPairs: NewUnknownPairs(unknown)}
+	tm.AddTrader(TraderConfig{Pair: btcusd})
+
+	// a book that was in flight when the pair was dropped from the config
+	tm.UpdateTraderConfigs(nil)
+	tm.routeOrderbook(sfoxBook{SFOXOrderbook: tc.SFOXOrderbook{Pair: btcusd}})
+	if _, ok := tm.trader(btcusd); ok {
+		t.Error("expected no monitor for a pair that was just removed")
+	}
+
+	// the router can get a monitor in between the reload checking for a trader and adding one
+	tm.removed[btcusd] = time.Now().Add(-removedPairGrace)
+	tm.routeOrderbook(sfoxBook{SFOXOrderbook: tc.SFOXOrderbook{Pair: btcusd}})
+	if trader, ok := tm.trader(btcusd); !ok || !trader.Config.MonitorOnly {
+		t.Fatal("expected a monitor once the pair's been gone for the grace period")
+	}
+	tm.AddTrader(TraderConfig{Pair: btcusd})
+	if trader, _ := tm.trader(btcusd); trader.Config.MonitorOnly {
+		t.Error("expected AddTrader to replace the monitor")
+	}
+}
+
+func TestUnknownPairsLogsAtMostOncePerInterval(t *testing.T) {
+	u := NewUnknownPairs(UnknownPairsConfig{LogInterval: Duration{time.Minute}})
+	pair := *tc.NewPair("ethusd")
+	start := time.Now()
+	if _, log := u.seen(pair, start); !log {
+		t.Error("expected the first book to be logged")
+	}
+	if _, log := u.seen(pair, start.Add(30*time.Second)); log {
+		t.Error("expected a book within the interval not to be logged")
+	}
+	if count, log := u.seen(pair, start.Add(time.Minute)); !log || count != 3 {
+		t.Errorf("expected the third book to be logged with a count of 3, got %d %v", count, log)
+	}
+}
+
+func TestOrdersNeverGoToSFOXWithoutLiveMarketData(t *testing.T) {
+	tm := &traderManager{Logger: testLogger, balances: NewSafeBalanceMap(), traders: make(map[tc.Pair]*Trader)}
+	called := false
+	err := tm.withVenue(EndpointOrder, func(orderVenue) error {
+		called = true
+		return nil
+	})
+	if err != ErrNoLiveOrders || called {
+		t.Errorf("expected the order to be refused, got %v", err)
+	}
+	tm.simulatedVenue = NewSimulatedVenue(DefaultSimulationConfig(), testLogger)
+	if err := tm.withVenue(EndpointOrder, func(orderVenue) error { return nil }); err != nil {
+		t.Errorf("expected the simulated venue to take the order, got %s", err.Error())
+	}
+}
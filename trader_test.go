@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	tc "github.com/ldcicconi/trading-common"
+	"github.com/shopspring/decimal"
+)
+
+func TestStopSellsWhatTheBuyFilled(t *testing.T) {
+	pair := *tc.NewPair("btcusd")
+	venue := NewSimulatedVenue(SimulationConfig{Balances: map[string]float64{"usd": 1000}}, testLogger)
+	venue.UpdateBook(sfoxBook{SFOXOrderbook: tc.SFOXOrderbook{
+		Pair:          pair,
+		SFOXTimestamp: time.Now(),
+		Orderbook: tc.Orderbook{
+			Asks: []tc.Offer{{Price: decimal.New(100, 0), Quantity: decimal.New(1, 0)}},
+			Bids: []tc.Offer{{Price: decimal.New(110, 0), Quantity: decimal.New(10, 0)}},
+		},
+	}})
+	tm := &traderManager{Logger: testLogger, balances: NewSafeBalanceMap(), traders: make(map[tc.Pair]*Trader), simulatedVenue: venue}
+	trader := NewTrader(TraderConfig{Pair: pair}, testLogger, tm)
+	trader.Start()
+	trader.arbChan <- arbStrat{
+		Pair:           pair,
+		Quantity:       decimal.New(2, 0),
+		BuyLimitPrice:  decimal.New(101, 0),
+		SellLimitPrice: decimal.New(105, 0),
+	}
+
+	// the book only has 1 to sell, so the buy is left half filled
+	for deadline := time.Now().Add(5 * time.Second); !venue.Balances()["btc"].Equal(decimal.New(1, 0)); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the buy to fill 1, balances are %v", venue.Balances())
+		}
+	}
+	trader.Stop()
+	if balances := venue.Balances(); !balances["btc"].IsZero() {
+		t.Errorf("expected what was bought to be sold by the time Stop returns, balances are %v", balances)
+	}
+}
+
+func TestStopGivesUpOnASellThatNeverFills(t *testing.T) {
+	pair := *tc.NewPair("btcusd")
+	venue := NewSimulatedVenue(SimulationConfig{Balances: map[string]float64{"usd": 1000}}, testLogger)
+	venue.UpdateBook(sfoxBook{SFOXOrderbook: tc.SFOXOrderbook{
+		Pair:          pair,
+		SFOXTimestamp: time.Now(),
+		Orderbook: tc.Orderbook{
+			Asks: []tc.Offer{{Price: decimal.New(100, 0), Quantity: decimal.New(1, 0)}},
+			Bids: []tc.Offer{{Price: decimal.New(90, 0), Quantity: decimal.New(10, 0)}},
+		},
+	}})
+	tm := &traderManager{Logger: testLogger, balances: NewSafeBalanceMap(), traders: make(map[tc.Pair]*Trader), simulatedVenue: venue}
+	trader := NewTrader(TraderConfig{Pair: pair}, testLogger, tm)
+	trader.unwindTimeout = 200 * time.Millisecond
+	trader.Start()
+	trader.arbChan <- arbStrat{
+		Pair:           pair,
+		Quantity:       decimal.New(1, 0),
+		BuyLimitPrice:  decimal.New(101, 0),
+		SellLimitPrice: decimal.New(105, 0),
+	}
+
+	// the buy fills, the exit sell rests above the bids
+	const sellID = 2
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := venue.OrderStatus(sellID); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the exit sell to be placed")
+		}
+	}
+	stopped := make(chan struct{})
+	go func() {
+		trader.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Stop to give up on the sell")
+	}
+	if status, _ := venue.OrderStatus(sellID); status.Status != "Canceled" {
+		t.Errorf("expected the sell to be canceled, got %+v", status)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	tc "github.com/ldcicconi/trading-common"
+)
+
+// UnknownPairsConfig is what the traderManager does with books for pairs that have no trader.
+// They're always counted and logged, at most once per LogInterval for each pair.
+type UnknownPairsConfig struct {
+	// Monitor starts a trader for the pair that logs the arbs it finds but never enters them.
+	// Its limits come from defaults and quoteDefaults, which have to set every limit for it.
+	Monitor     bool                   `json:"monitor"`
+	LogInterval Duration               `json:"logInterval"`
+	limits      map[string]TradeLimits // by quote currency, filled in by ParseConfig when Monitor is on
+}
+
+func DefaultUnknownPairsConfig() UnknownPairsConfig {
+	return UnknownPairsConfig{
+		LogInterval: Duration{time.Minute},
+	}
+}
+
+func (c UnknownPairsConfig) validate() (errs []error) {
+	if c.LogInterval.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("unknownPairs: logInterval must be greater than 0"))
+	}
+	return errs
+}
+
+// monitorConfig is the config for a monitoring only trader for pair
+func (c UnknownPairsConfig) monitorConfig(pair tc.Pair) (TraderConfig, error) {
+	class, err := pairClassOf(pair)
+	if err != nil {
+		return TraderConfig{}, err
+	}
+	limits, ok := c.limits[class.Quote]
+	if !ok {
+		return TraderConfig{}, fmt.Errorf("no limits for quote currency %s", class.Quote)
+	}
+	config := NewTraderConfig(pair, limits)
+	config.MonitorOnly = true
+	return *config, nil
+}
+
+// unknownPairs counts the books routed for pairs without a trader
+type unknownPairs struct {
+	config     UnknownPairsConfig
+	counts     map[tc.Pair]uint64
+	lastLogged map[tc.Pair]time.Time
+	lock       sync.Mutex
+}
+
+func NewUnknownPairs(config UnknownPairsConfig) *unknownPairs {
+	return &unknownPairs{
+		config:     config,
+		counts:     make(map[tc.Pair]uint64),
+		lastLogged: make(map[tc.Pair]time.Time),
+	}
+}
+
+// seen counts a book for pair, and returns the count so far and whether it's time to log it
+func (u *unknownPairs) seen(pair tc.Pair, now time.Time) (count uint64, log bool) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.counts[pair]++
+	if now.Sub(u.lastLogged[pair]) < u.config.LogInterval.Duration {
+		return u.counts[pair], false
+	}
+	u.lastLogged[pair] = now
+	return u.counts[pair], true
+}
+
+// Counts returns the number of books seen for each unknown pair since startup
+func (u *unknownPairs) Counts() map[tc.Pair]uint64 {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	counts := make(map[tc.Pair]uint64)
+	for pair, n := range u.counts {
+		counts[pair] = n
+	}
+	return counts
+}
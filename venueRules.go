@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	tc "github.com/ldcicconi/trading-common"
+	"github.com/shopspring/decimal"
+)
+
+// VenueRules adjust a pair's book before FindArb walks it, for venues that fill our smart orders
+// less reliably than their offers suggest
+//
+//	{
+//	  "allow": ["gemini", "itbit", "bitstamp"],
+//	  "deny": ["market1"],
+//	  "venues": {"bitstamp": {"minSize": "0.05", "haircutBps": "3"}}
+//	}
+type VenueRules struct {
+	Allow  []string             `json:"allow"` // if set, only offers from these venues are used
+	Deny   []string             `json:"deny"`
+	Venues map[string]VenueRule `json:"venues"`
+}
+
+type VenueRule struct {
+	MinSize    decimal.Decimal `json:"minSize"`    // offers smaller than this, in the base currency, are ignored
+	HaircutBps decimal.Decimal `json:"haircutBps"` // bids are marked down and asks marked up by this much
+}
+
+// VenueRulesConfig is the venueRules section of the config file. A pair's rules start from the defaults:
+// its own allow list replaces the default one, deny lists are combined, and its per-venue rules replace the
+// defaults' rules for the same venue.
+type VenueRulesConfig struct {
+	Defaults VenueRules            `json:"defaults"`
+	Pairs    map[string]VenueRules `json:"pairs"`
+}
+
+func (c VenueRulesConfig) forPair(pair string) VenueRules {
+	rules := VenueRules{
+		Allow:  c.Defaults.Allow,
+		Deny:   append([]string(nil), c.Defaults.Deny...),
+		Venues: make(map[string]VenueRule),
+	}
+	for venue, rule := range c.Defaults.Venues {
+		rules.Venues[venue] = rule
+	}
+	if override, ok := c.Pairs[pair]; ok {
+		if override.Allow != nil {
+			rules.Allow = override.Allow
+		}
+		rules.Deny = append(rules.Deny, override.Deny...)
+		for venue, rule := range override.Venues {
+			rules.Venues[venue] = rule
+		}
+	}
+	return rules
+}
+
+// validate checks that every pair with rules is configured, the rules themselves are checked per trader
+func (c VenueRulesConfig) validate(pairs map[tc.Pair]bool) (errs []error) {
+	for pair := range c.Pairs {
+		if !pairs[*tc.NewPair(pair)] {
+			errs = append(errs, fmt.Errorf("venueRules.pairs: %s is not a configured pair", pair))
+		}
+	}
+	return errs
+}
+
+func (r VenueRules) validate() (errs []error) {
+	for _, venue := range r.Deny {
+		if len(r.Allow) > 0 && r.allows(venue) {
+			errs = append(errs, fmt.Errorf("venue %s is both allowed and denied", venue))
+		}
+	}
+	for venue, rule := range r.Venues {
+		if rule.MinSize.LessThan(decimal.Zero) || rule.HaircutBps.LessThan(decimal.Zero) {
+			errs = append(errs, fmt.Errorf("venue %s: minSize and haircutBps must not be negative", venue))
+		}
+		if rule.HaircutBps.GreaterThanOrEqual(decimal.New(1, 4)) {
+			errs = append(errs, fmt.Errorf("venue %s: haircutBps must be less than 10000", venue))
+		}
+	}
+	return errs
+}
+
+// allows is true if venue is in the allow list, or there isn't one
+func (r VenueRules) allows(venue string) bool {
+	if len(r.Allow) == 0 {
+		return true
+	}
+	for _, v := range r.Allow {
+		if v == venue {
+			return true
+		}
+	}
+	return false
+}
+
+func (r VenueRules) denies(venue string) bool {
+	for _, v := range r.Deny {
+		if v == venue {
+			return true
+		}
+	}
+	return false
+}
+
+func (r VenueRules) IsZero() bool {
+	return len(r.Allow) == 0 && len(r.Deny) == 0 && len(r.Venues) == 0
+}
+
+func (r VenueRules) Equal(other VenueRules) bool {
+	equalStrings := func(a, b []string) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if a[i] != b[i] {
+				return false
+			}
+		}
+		return true
+	}
+	if !equalStrings(r.Allow, other.Allow) || !equalStrings(r.Deny, other.Deny) || len(r.Venues) != len(other.Venues) {
+		return false
+	}
+	for venue, rule := range r.Venues {
+		o, ok := other.Venues[venue]
+		if !ok || !rule.MinSize.Equal(o.MinSize) || !rule.HaircutBps.Equal(o.HaircutBps) {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply returns a copy of b with the rules applied, still sorted best price first.
+// The rules can't be applied to a book whose venues aren't known, so it comes back without any offers.
+func (r VenueRules) Apply(b sfoxBook) sfoxBook {
+	if r.IsZero() {
+		return b
+	}
+	b = b.withoutVenues(func(venue string) bool { return !r.allows(venue) || r.denies(venue) })
+	if b.BidVenues == nil || b.AskVenues == nil {
+		return b
+	}
+	b.Bids, b.BidVenues = r.adjust(b.Bids, b.BidVenues, tc.SIDE_BUY)
+	b.Asks, b.AskVenues = r.adjust(b.Asks, b.AskVenues, tc.SIDE_SELL)
+	return b
+}
+
+// adjust drops offers under their venue's minimum size and applies haircuts, then re-sorts.
+// side is the side of the offers, bids are buy orders. offers is already a copy and is modified in place.
+func (r VenueRules) adjust(offers []tc.Offer, venues []string, side tc.Side) ([]tc.Offer, []string) {
+	keptOffers, keptVenues := offers[:0], venues[:0]
+	haircut := false
+	for i, offer := range offers {
+		rule, ok := r.Venues[venues[i]]
+		if ok && offer.Quantity.LessThan(rule.MinSize) {
+			continue
+		}
+		if ok && rule.HaircutBps.GreaterThan(decimal.Zero) {
+			factor := rule.HaircutBps.Div(decimal.New(1, 4))
+			if side == tc.SIDE_BUY {
+				offer.Price = offer.Price.Mul(tc.One.Sub(factor))
+			} else {
+				offer.Price = offer.Price.Mul(tc.One.Add(factor))
+			}
+			haircut = true
+		}
+		keptOffers = append(keptOffers, offer)
+		keptVenues = append(keptVenues, venues[i])
+	}
+	if haircut {
+		sort.Stable(offersByPrice{keptOffers, keptVenues, side == tc.SIDE_BUY})
+	}
+	return keptOffers, keptVenues
+}
+
+// offersByPrice sorts offers and their venues together, best first
+type offersByPrice struct {
+	offers     []tc.Offer
+	venues     []string
+	descending bool
+}
+
+func (o offersByPrice) Len() int { return len(o.offers) }
+
+func (o offersByPrice) Less(i, j int) bool {
+	if o.descending {
+		return o.offers[i].Price.GreaterThan(o.offers[j].Price)
+	}
+	return o.offers[i].Price.LessThan(o.offers[j].Price)
+}
+
+func (o offersByPrice) Swap(i, j int) {
+	o.offers[i], o.offers[j] = o.offers[j], o.offers[i]
+	o.venues[i], o.venues[j] = o.venues[j], o.venues[i]
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	tc "github.com/ldcicconi/trading-common"
+	"github.com/shopspring/decimal"
+)
+
+func TestVenueRulesApply(t *testing.T) {
+	offer := func(price, quantity float64) tc.Offer {
+		return tc.Offer{Price: decimal.NewFromFloat(price), Quantity: decimal.NewFromFloat(quantity)}
+	}
+	b := sfoxBook{
+		SFOXOrderbook: tc.SFOXOrderbook{
+			Pair: *tc.NewPair("btcusd"),
+			Orderbook: tc.Orderbook{
+				Bids: []tc.Offer{offer(101, 1), offer(100.5, 1), offer(100, 0.01), offer(99, 1)},
+				Asks: []tc.Offer{offer(102, 1), offer(102.5, 1)},
+			},
+		},
+		BidVenues: []string{"bitstamp", "gemini", "itbit", "market1"},
+		AskVenues: []string{"bitstamp", "gemini"},
+	}
+	rules := VenueRules{
+		Deny: []string{"market1"},
+		Venues: map[string]VenueRule{
+			"bitstamp": {HaircutBps: decimal.New(100, 0)}, // 1%
+			"itbit":    {MinSize: decimal.NewFromFloat(0.1)},
+		},
+	}
+	applied := rules.Apply(b)
+	if !reflect.DeepEqual(applied.BidVenues, []string{"gemini", "bitstamp"}) {
+		t.Errorf("expected the bitstamp bid to sort below gemini after its haircut, and itbit and market1 to be dropped, got %v", applied.BidVenues)
+	}
+	if !applied.Bids[1].Price.Equal(decimal.NewFromFloat(99.99)) {
+		t.Errorf("expected the bitstamp bid to be marked down to 99.99, got %s", applied.Bids[1].Price)
+	}
+	if !reflect.DeepEqual(applied.AskVenues, []string{"gemini", "bitstamp"}) || !applied.Asks[1].Price.Equal(decimal.NewFromFloat(103.02)) {
+		t.Errorf("expected the bitstamp ask to be marked up to 103.02, got %v %v", applied.AskVenues, applied.Asks)
+	}
+	if !b.Bids[0].Price.Equal(decimal.New(101, 0)) || len(b.Bids) != 4 {
+		t.Errorf("the original book was modified")
+	}
+	allowed := VenueRules{Allow: []string{"gemini"}}.Apply(b)
+	if !reflect.DeepEqual(allowed.BidVenues, []string{"gemini"}) || !reflect.DeepEqual(allowed.AskVenues, []string{"gemini"}) {
+		t.Errorf("expected only gemini offers, got %v %v", allowed.BidVenues, allowed.AskVenues)
+	}
+	b.BidVenues, b.AskVenues = nil, nil
+	if unknown := rules.Apply(b); len(unknown.Bids) != 0 || len(unknown.Asks) != 0 {
+		t.Errorf("expected a book without venues to come back without offers, got %d bids and %d asks", len(unknown.Bids), len(unknown.Asks))
+	}
+	if unfiltered := (VenueRules{}).Apply(b); len(unfiltered.Bids) != 4 {
+		t.Errorf("expected a book without venues to be left alone when there are no rules")
+	}
+}
+
+func TestParseConfigVenueRules(t *testing.T) {
+	file := strings.Replace(testConfigFile, `"pairs"`, `"venueRules": {
+		"defaults": {"deny": ["market1"], "venues": {"bittrex": {"minSize": "0.01"}}},
+		"pairs": {"btcusd": {"deny": ["itbit"], "venues": {"bittrex": {"haircutBps": 5}}}}
+	}, "pairs"`, 1)
+	config, err := ParseConfig([]byte(file))
+	if err != nil {
+		t.Fatalf("unexpected error parsing config: %s", err.Error())
+	}
+	btc, eth := config.Traders[0].Venues, config.Traders[1].Venues
+	if !reflect.DeepEqual(btc.Deny, []string{"market1", "itbit"}) || !btc.Venues["bittrex"].HaircutBps.Equal(decimal.New(5, 0)) || !btc.Venues["bittrex"].MinSize.IsZero() {
+		t.Errorf("btcusd rules not layered on the defaults: %+v", btc)
+	}
+	if !reflect.DeepEqual(eth.Deny, []string{"market1"}) || !eth.Venues["bittrex"].MinSize.Equal(decimal.New(1, -2)) {
+		t.Errorf("ethusd did not get the default rules: %+v", eth)
+	}
+
+	bad := strings.Replace(testConfigFile, `"pairs"`, `"venueRules": {
+		"defaults": {"allow": ["gemini"], "deny": ["gemini"]},
+		"pairs": {"ltcusd": {}}
+	}, "pairs"`, 1)
+	_, err = ParseConfig([]byte(bad))
+	if err == nil || !strings.Contains(err.Error(), "both allowed and denied") || !strings.Contains(err.Error(), "ltcusd is not a configured pair") {
+		t.Errorf("expected both venue rule problems to be reported, got %v", err)
+	}
+}
+
+func TestVenueRulesThatEmptyASideDontTrade(t *testing.T) {
+	pair := *tc.NewPair("btcusd")
+	tm := &traderManager{Logger: testLogger, balances: NewSafeBalanceMap(), traders: make(map[tc.Pair]*Trader)}
+	tm.balances.m[pair.Quote] = decimal.New(1000, 0)
+	limits := TradeLimits{MaxOrderQuantity: decimal.New(10, 0), MaxOrderAmount: decimal.New(1000, 0)}
+	// crossed, but bitstamp is only bidding
+	b := sfoxBook{
+		SFOXOrderbook: tc.SFOXOrderbook{
+			Pair: pair,
+			Orderbook: tc.Orderbook{
+				Bids: []tc.Offer{{Price: decimal.New(105, 0), Quantity: decimal.New(1, 0)}},
+				Asks: []tc.Offer{{Price: decimal.New(100, 0), Quantity: decimal.New(1, 0)}},
+			},
+		},
+		BidVenues: []string{"bitstamp"},
+		AskVenues: []string{"gemini"},
+	}
+	trade := func(rules VenueRules) bool {
+		trader := NewTrader(TraderConfig{Pair: pair, TradeLimits: limits, Venues: rules}, testLogger, tm)
+		trader.arbChan = make(chan arbStrat, 1)
+		trader.handleOrderbook(b)
+		return len(trader.arbChan) > 0
+	}
+	if !trade(VenueRules{}) {
+		t.Fatal("expected an arb without venue rules")
+	}
+	if trade(VenueRules{Allow: []string{"bitstamp"}}) {
+		t.Error("expected no arb once the allow list leaves no asks")
+	}
+	if trade(VenueRules{Venues: map[string]VenueRule{"bitstamp": {MinSize: decimal.New(2, 0)}}}) {
+		t.Error("expected no arb once minSize leaves no bids")
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	ws "github.com/ldcicconi/ws-contractor"
+)
+
+// wsConnection is a websocket connection to the feed. Unlike a ws-contractor connection it can be sent
+// messages once it's open, to change subscriptions, and closed when it's given up on.
+type wsConnection struct {
+	url        url.URL
+	conn       *websocket.Conn
+	writeLock  sync.Mutex
+	closed     bool
+	closedLock sync.Mutex
+}
+
+// dialWS connects to u and sends subMessage. isSecure picks the scheme when u doesn't have one.
+func dialWS(u url.URL, subMessage []byte, isSecure bool) (*wsConnection, error) {
+	if u.Scheme == "" {
+		u.Scheme = "ws"
+		if isSecure {
+			u.Scheme = "wss"
+		}
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	c := &wsConnection{url: u, conn: conn}
+	if err = c.Send(subMessage); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Send writes a text message, it's safe to call from any goroutine
+func (c *wsConnection) Send(msg []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	return c.conn.WriteMessage(websocket.TextMessage, msg)
+}
+
+// Consume reads messages into messages until the connection fails or is closed, then closes messages
+func (c *wsConnection) Consume(messages chan ws.MessageEnvelope) {
+	go func() {
+		defer close(messages)
+		for {
+			_, payload, err := c.conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			messages <- ws.MessageEnvelope{Payload: payload, ReceiptTimestamp: time.Now()}
+		}
+	}()
+}
+
+// Close closes the connection, which ends Consume. Closing it again does nothing.
+func (c *wsConnection) Close() error {
+	c.closedLock.Lock()
+	defer c.closedLock.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.conn.Close()
+}